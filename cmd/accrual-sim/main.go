@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/accrualsim"
+)
+
+const (
+	// сколько ждём времени на корректное завершение работы сервера
+	closeServerTimeout = time.Second * 3
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Старт симулятора системы начислений
+func run() error {
+	var (
+		addr           string
+		logLevel       string
+		defaultAccrual string
+		opts           accrualsim.Options
+	)
+	flag.StringVar(&addr, "a", "localhost:8090", "server address")
+	flag.StringVar(&logLevel, "l", "info", "log level")
+	flag.DurationVar(&opts.RegisterDelay, "register-delay", 0, "time before registered order is visible (204 until then)")
+	flag.DurationVar(&opts.RegisteredFor, "registered", 0, "time order stays REGISTERED")
+	flag.DurationVar(&opts.ProcessingFor, "processing", 0, "time order stays PROCESSING")
+	flag.Float64Var(&opts.InvalidRate, "invalid-rate", 0, "share of orders that become INVALID, 0..1")
+	flag.Float64Var(&opts.ErrorRate, "error-rate", 0, "share of requests answered with 500, 0..1")
+	flag.IntVar(&opts.RateLimit, "rate-limit", 0, "requests per minute before 429, 0 - unlimited")
+	flag.BoolVar(&opts.AutoRegister, "auto-register", false, "register unknown orders on first request")
+	flag.StringVar(&defaultAccrual, "default-accrual", "0", "accrual for orders without goods")
+	flag.Int64Var(&opts.Seed, "seed", time.Now().UnixNano(), "random seed")
+	flag.Parse()
+
+	if v, ok := os.LookupEnv("RUN_ADDRESS"); ok {
+		addr = v
+	}
+
+	var err error
+	if opts.DefaultAccrual, err = model.ParsePoints(defaultAccrual); err != nil {
+		return err
+	}
+	if err = logger.Initialize(logLevel); err != nil {
+		return err
+	}
+
+	// захват прерывания процесса
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: accrualsim.New(opts),
+	}
+
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- server.ListenAndServe()
+	}()
+	logger.Info("accrual simulator start", "address", addr, "options", opts)
+
+	select {
+	case <-ctx.Done():
+	case err = <-srvErr:
+		return err
+	}
+
+	ctxTimeout, cancel := context.WithTimeout(context.Background(), closeServerTimeout)
+	defer cancel()
+	if err = server.Shutdown(ctxTimeout); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("accrual simulator stop")
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+
+	"github.com/eugene982/yp-gophermart/internal/config"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/services/jwtkeys"
+	"github.com/eugene982/yp-gophermart/internal/services/loginguard"
+)
+
+// Служебные команды, выполняемые вместо запуска сервера:
+//
+//	gophermart [flags] migrate up|down|status
+//	gophermart [flags] reconcile [fix]
+//	gophermart [flags] history <order>
+//	gophermart [flags] stuck
+//	gophermart [flags] requeue <order>...
+//	gophermart [flags] audit [limit]
+//	gophermart [flags] unlock <subject>...
+//	gophermart jwt-keygen [HS256|RS256|EdDSA] [kid]
+func runCommand(ctx context.Context, conf config.Configuration) error {
+	name, args := conf.Command[0], conf.Command[1:]
+
+	switch name {
+	case "migrate":
+		return runMigrate(ctx, conf, args)
+	case "reconcile":
+		return runReconcile(ctx, conf, args)
+	case "history":
+		return runHistory(ctx, conf, args)
+	case "stuck":
+		return runStuck(ctx, conf, args)
+	case "requeue":
+		return runRequeue(ctx, conf, args)
+	case "audit":
+		return runAudit(ctx, conf, args)
+	case "unlock":
+		return runUnlock(ctx, conf, args)
+	case "jwt-keygen":
+		return runJWTKeygen(args)
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// Управление миграциями схемы базы данных
+func runMigrate(ctx context.Context, conf config.Configuration, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gophermart migrate up|down|status")
+	}
+
+	status, err := database.Migrate(ctx, conf.DatabaseDSN, args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
+
+// Сверка балансов пользователей с историей операций
+func runReconcile(ctx context.Context, conf config.Configuration, args []string) error {
+	fix := len(args) == 1 && args[0] == "fix"
+	if len(args) > 1 || (len(args) == 1 && !fix) {
+		return fmt.Errorf("usage: gophermart reconcile [fix]")
+	}
+
+	db, err := database.Open(conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	drifts, err := db.ReconcileBalances(ctx, fix)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tCURRENT\tEXPECTED\tWITHDRAWN\tEXPECTED")
+	for _, d := range drifts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.UserID,
+			d.Current, d.ExpectedCurrent, d.Withdrawn, d.ExpectedWithdrawn)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case len(drifts) == 0:
+		fmt.Println("balances are consistent")
+	case fix:
+		fmt.Printf("fixed %d balances\n", len(drifts))
+	default:
+		return fmt.Errorf("found %d inconsistent balances", len(drifts))
+	}
+	return nil
+}
+
+// История изменения статусов заказа
+func runHistory(ctx context.Context, conf config.Configuration, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gophermart history <order>")
+	}
+	num, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid order number %q", args[0])
+	}
+
+	db, err := database.Open(conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	history, err := db.ReadOrderHistory(ctx, num)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return fmt.Errorf("order %d not found", num)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGED AT\tFROM\tTO")
+	for _, c := range history {
+		from := string(c.From)
+		if from == "" {
+			from = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.ChangedAt.Format(time.RFC3339), from, c.To)
+	}
+	return w.Flush()
+}
+
+// Список заказов, которые система начислений так и не обработала
+func runStuck(ctx context.Context, conf config.Configuration, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: gophermart stuck")
+	}
+
+	db, err := database.Open(conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	orders, err := db.ReadOrdersWithStatus(ctx, []model.OrderStatus{model.StatusStuck}, 0)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORDER\tUSER\tUPLOADED AT\tATTEMPTS\tLAST ERROR")
+	for _, o := range orders {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", o.OrderID, o.UserID,
+			o.UploadedAt.Format(time.RFC3339), o.Attempts, o.LastError)
+	}
+	return w.Flush()
+}
+
+// Последние события журнала аудита, сначала новые
+func runAudit(ctx context.Context, conf config.Configuration, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: gophermart audit [limit]")
+	}
+	limit := 50
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid limit %q", args[0])
+		}
+		limit = n
+	}
+
+	db, err := database.Open(conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	events, err := db.ReadAuditEvents(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED AT	EVENT	SUBJECT	REMOTE ADDR	DETAILS")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format(time.RFC3339),
+			e.Event, e.Subject, e.RemoteAddr, e.Details)
+	}
+	return w.Flush()
+}
+
+// Снятие блокировки входа до её истечения, субъект как в журнале аудита:
+// login:<логин> или ip:<адрес>
+func runUnlock(ctx context.Context, conf config.Configuration, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gophermart unlock login:<login>|ip:<addr>...")
+	}
+	for _, subject := range args {
+		if !strings.HasPrefix(subject, loginguard.LoginKeyPrefix) && !strings.HasPrefix(subject, loginguard.IPKeyPrefix) {
+			return fmt.Errorf("invalid subject %q, expected login:<login> or ip:<addr>", subject)
+		}
+	}
+
+	db, err := database.Open(conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, subject := range args {
+		if err = db.ResetLoginAttempts(ctx, subject); err != nil {
+			return err
+		}
+		fmt.Printf("%s unlocked\n", subject)
+	}
+	return nil
+}
+
+// Возврат зависших заказов в очередь опроса
+func runRequeue(ctx context.Context, conf config.Configuration, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gophermart requeue <order>...")
+	}
+
+	nums := make([]int64, len(args))
+	for i, arg := range args {
+		num, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid order number %q", arg)
+		}
+		nums[i] = num
+	}
+
+	db, err := database.Open(conf.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, num := range nums {
+		if err = db.RequeueOrder(ctx, num); err != nil {
+			return fmt.Errorf("requeue order %d: %w", num, err)
+		}
+		fmt.Printf("order %d requeued\n", num)
+	}
+	return nil
+}
+
+// Новый ключ подписи токенов в формате JWK, добавляется в файл JWKS
+func runJWTKeygen(args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("usage: gophermart jwt-keygen [HS256|RS256|EdDSA] [kid]")
+	}
+	alg, kid := jwtkeys.EdDSA, ""
+	if len(args) > 0 {
+		alg = jwa.SignatureAlgorithm(args[0])
+	}
+	if len(args) > 1 {
+		kid = args[1]
+	}
+
+	key, err := jwtkeys.Generate(alg, kid)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(key)
+}
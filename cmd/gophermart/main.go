@@ -44,17 +44,21 @@ func run() (err error) {
 	defer stop()
 
 	conf := config.Config()
+	if len(conf.Command) > 0 {
+		return runCommand(ctxInterrupt, conf)
+	}
+
 	app, err := application.New(conf)
 	if err != nil {
 		return
 	}
 
 	// запуск сервера в горутине
-	srvErr := make(chan error)
+	srvErr := make(chan error, 1)
 	go func() {
-		srvErr <- app.Start()
+		srvErr <- app.Start(ctxInterrupt)
 	}()
-	logger.Info("application start", "config", conf)
+	logger.Info("application start", "config", conf.Redacted())
 
 	// ждём что раньше случится, ошибка старта сервера
 	// или пользователь прервёт программу
@@ -67,17 +71,18 @@ func run() (err error) {
 		logger.Error(fmt.Errorf("error start server: %w", e))
 	}
 
-	// стартуем завершение сервера
-	closeErr := make(chan error)
-	go func() {
-		closeErr <- app.Close()
-	}()
-
 	// Ждём пока сервер сам завершится
 	// или за отведённое время
 	ctxTimeout, stop := context.WithTimeout(context.Background(), closeServerTimeout)
 	defer stop()
 
+	// стартуем завершение сервера, срок ожидания общий
+	// для сервера и опроса внешней системы
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- app.Close(ctxTimeout)
+	}()
+
 	select {
 	case <-ctxTimeout.Done():
 		logger.Warn("stop server on timeout")
@@ -1,32 +1,59 @@
 package application
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
 	"github.com/eugene982/yp-gophermart/internal/config"
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/metrics"
+	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/services/clients"
+	"github.com/eugene982/yp-gophermart/internal/services/jwtkeys"
+	"github.com/eugene982/yp-gophermart/internal/services/loginguard"
+	"github.com/eugene982/yp-gophermart/internal/services/passwords"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
+	"github.com/eugene982/yp-gophermart/internal/tracing"
 
 	"github.com/eugene982/yp-gophermart/internal/services/database"
+	_ "github.com/eugene982/yp-gophermart/internal/services/database/memory"   // чтоб init() отработал
 	_ "github.com/eugene982/yp-gophermart/internal/services/database/postgres" // чтоб init() отработал
 )
 
 const (
-	// подмешиваем в пароль при получении хеша
-	passwordSalt = "YandexPracticumSalt"
+	// соль устаревших хешей паролей, нужна, чтобы заменить их при входе
+	legacyPasswordSalt = "YandexPracticumSalt"
 
 	// период между опросами внешнего сервиса
 	accrueReqestDuration = time.Second * 5
 
-	// Количество заказов для обновления, получаемые из БД
-	updateOrderLimit = 10
+	// период удаления просроченных ключей идемпотентности
+	idempotencyCleanupDuration = time.Hour
 )
 
+// без ключей токены одного экземпляра не принимаются другими
+// и перестают действовать после перезапуска
+var errNoTokenKeys = errors.New("jwt keys are not configured: set -jwt-keys or -jwt-secret, or -jwt-ephemeral for development")
+
 type Application struct {
 	storage database.Database      // база данных, хранилище
 	server  *http.Server           // запускаемый сервер при старте приложения
 	client  *clients.AccrualClient // клиент опроса внешней системы
+
+	metrics []prometheus.Collector // метрики компонентов, снимаются при закрытии
+
+	stopCleanup context.CancelFunc // остановка периодической очистки
+	cleanupDone chan struct{}      // закрывается по завершении очистки
+
+	shutdownTracing tracing.ShutdownFunc // выгрузка накопленных трасс
 }
 
 // Создание экземпляра приложения
@@ -37,49 +64,241 @@ func New(conf config.Configuration) (*Application, error) {
 		a   Application
 	)
 
-	// установка соединения БД
-	a.storage, err = database.Open(conf.DatabaseDSN)
+	// установка соединения БД, вызовы пишутся в метрики и трассы
+	storage, err := database.Open(conf.DatabaseDSN)
 	if err != nil {
 		return nil, err
 	}
+	a.storage = database.Instrument(storage)
 
 	// клиент, который опрашивает внешний ресурс
 	if conf.AccrualSystemAddress != "" {
 		a.client, err = clients.NewAccrualClient(time.Second*time.Duration(conf.Timeout),
-			conf.AccrualSystemAddress, updateOrderLimit)
+			conf.AccrualSystemAddress, clients.AccrualOptions{
+				Workers:      conf.AccrualWorkers,
+				BatchSize:    conf.AccrualBatchSize,
+				OrderTimeout: time.Second * time.Duration(conf.AccrualOrderTimeout),
+
+				InstanceID:    conf.InstanceID,
+				LeaseDuration: time.Second * time.Duration(conf.AccrualLease),
+
+				BackoffBase: time.Second * time.Duration(conf.AccrualBackoff),
+				BackoffMax:  time.Second * time.Duration(conf.AccrualBackoffMax),
+
+				MaxAttempts: conf.AccrualMaxAttempts,
+				MaxAge:      time.Second * time.Duration(conf.AccrualMaxAge),
+
+				BreakerFailures: conf.AccrualBreakerFailures,
+				BreakerCooldown: time.Second * time.Duration(conf.AccrualBreakerCooldown),
+			})
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if err = a.registerMetrics(storage); err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	// интерфейс с nil-указателем не равен nil
+	var breaker handlers.CircuitBreaker
+	if a.client != nil {
+		breaker = a.client
+	}
+
+	hasher, err := passwords.NewHasher(passwords.Options{
+		Algorithm:  conf.PasswordHash,
+		LegacySalt: legacyPasswordSalt,
+	})
+	if err != nil {
+		a.unregisterMetrics()
+		storage.Close()
+		return nil, err
+	}
+
+	keys, err := loadTokenKeys(conf)
+	if err != nil {
+		a.unregisterMetrics()
+		storage.Close()
+		return nil, err
+	}
+	logger.Info("jwt signing key", "kid", keys.SigningKeyID())
+	tokens := sessions.New(keys, a.storage, sessions.Options{
+		AccessTTL:  time.Second * time.Duration(conf.AccessTokenTTL),
+		RefreshTTL: time.Second * time.Duration(conf.RefreshTokenTTL),
+	})
+
+	tokenWriter, err := newTokenWriter(conf)
+	if err != nil {
+		a.unregisterMetrics()
+		storage.Close()
+		return nil, err
+	}
+
 	a.server = &http.Server{
 		Addr:         conf.ServAddr,
 		WriteTimeout: time.Second * time.Duration(conf.Timeout),
 		ReadTimeout:  time.Second * time.Duration(conf.Timeout),
-		Handler:      newRouter(a.storage),
+		Handler:      newRouter(a.storage, breaker, hasher, tokens, tokenWriter, conf),
+	}
+
+	// трассировка, по умолчанию отключена
+	a.shutdownTracing, err = tracing.Init(context.Background(),
+		conf.TraceExporter, conf.TraceEndpoint, conf.InstanceID)
+	if err != nil {
+		a.unregisterMetrics()
+		storage.Close()
+		return nil, err
 	}
 
 	return &a, nil
 }
 
-func (a *Application) Start() error {
-	// Стартуем опрос внешней системы в отдельной горутине
+// Ключи подписи токенов: файл JWKS, секрет или, только при явном
+// -jwt-ephemeral, случайный ключ
+func loadTokenKeys(conf config.Configuration) (*jwtkeys.KeySet, error) {
+	switch {
+	case conf.JWTKeysFile != "" && conf.JWTSecret != "":
+		return nil, fmt.Errorf("jwt keys file and jwt secret are mutually exclusive")
+	case conf.JWTKeysFile != "":
+		return jwtkeys.Load(conf.JWTKeysFile, conf.JWTSigningKeyID)
+	case conf.JWTSecret != "":
+		return jwtkeys.FromSecret(conf.JWTSecret)
+	case conf.JWTEphemeral:
+		logger.Warn("jwt ephemeral key, tokens are valid until restart of this instance")
+		return jwtkeys.Ephemeral()
+	}
+	return nil, errNoTokenKeys
+}
+
+// Выдача токенов клиентам с атрибутами кук окружения
+func newTokenWriter(conf config.Configuration) (*middleware.TokenWriter, error) {
+	sameSite, err := middleware.ParseSameSite(conf.CookieSameSite)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.NewTokenWriter(middleware.CookieOptions{
+		Secure:   conf.CookieSecure,
+		SameSite: sameSite,
+		Domain:   conf.CookieDomain,
+	})
+}
+
+// Настройки защиты входа от перебора паролей
+func newLoginGuardOptions(conf config.Configuration) loginguard.Options {
+	opts := loginguard.DefaultOptions()
+	opts.Login.MaxFailures = conf.LoginMaxFailures
+	opts.IP.MaxFailures = conf.LoginIPMaxFailures
+	opts.Lockout = time.Second * time.Duration(conf.LoginLockout)
+	return opts
+}
+
+// Регистрация метрик пула соединений, заказов и опроса внешней системы
+func (a *Application) registerMetrics(storage database.Database) error {
+	list := []prometheus.Collector{database.NewOrdersCollector(storage)}
+	if pool, ok := storage.(interface{ DB() *sql.DB }); ok {
+		list = append(list, collectors.NewDBStatsCollector(pool.DB(), "gophermart"))
+	}
 	if a.client != nil {
-		a.client.StartReqestAsync(a.storage, accrueReqestDuration)
+		list = append(list, a.client)
 	}
 
-	return a.server.ListenAndServe()
+	for _, c := range list {
+		if err := metrics.Register(c); err != nil {
+			a.unregisterMetrics()
+			return fmt.Errorf("register metrics: %w", err)
+		}
+		a.metrics = append(a.metrics, c)
+	}
+	return nil
+}
+
+// Снятие метрик компонентов, чтобы следующий экземпляр мог их зарегистрировать
+func (a *Application) unregisterMetrics() {
+	for _, c := range a.metrics {
+		metrics.Unregister(c)
+	}
+	a.metrics = nil
 }
 
-// Освобождение ресурсов приложения
-func (a *Application) Close() error {
+// Запуск сервера, опрос внешней системы прекращается при отмене ctx
+func (a *Application) Start(ctx context.Context) error {
+	// Стартуем опрос внешней системы в отдельной горутине
 	if a.client != nil {
-		a.client.Stop()
+		a.client.StartReqestAsync(ctx, a.storage, accrueReqestDuration)
 	}
+	a.startCleanup(ctx)
 
-	err := a.storage.Close()
-	if e := a.server.Close(); err != nil && e != nil && !errors.Is(err, http.ErrServerClosed) {
-		err = e
+	err := a.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
 	return err
 }
+
+// Освобождение ресурсов приложения: сервер перестаёт принимать запросы,
+// опрос внешней системы дожидается обработчиков. Ожидание ограничено ctx,
+// соединение с БД закрывается в любом случае
+func (a *Application) Close(ctx context.Context) error {
+	var errs []error
+	if err := a.server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("shutdown server: %w", err))
+	}
+	if a.client != nil {
+		if err := a.client.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := a.closeCleanup(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	a.unregisterMetrics()
+	if err := a.storage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close storage: %w", err))
+	}
+	if err := a.shutdownTracing(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("shutdown tracing: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Периодическое удаление просроченных ключей идемпотентности
+func (a *Application) startCleanup(ctx context.Context) {
+	ctx, a.stopCleanup = context.WithCancel(ctx)
+	a.cleanupDone = make(chan struct{})
+
+	go func() {
+		defer close(a.cleanupDone)
+		ticker := time.NewTicker(idempotencyCleanupDuration)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := a.storage.DeleteExpiredIdempotencyKeys(ctx)
+				if err != nil {
+					logger.Error(fmt.Errorf("delete expired idempotency keys: %w", err))
+				} else if n > 0 {
+					logger.Info("expired idempotency keys deleted", "count", n)
+				}
+			}
+		}
+	}()
+}
+
+// Остановка очистки с ожиданием текущего удаления, не дольше ctx
+func (a *Application) closeCleanup(ctx context.Context) error {
+	if a.stopCleanup == nil {
+		return nil
+	}
+	a.stopCleanup()
+	select {
+	case <-a.cleanupDone:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stop idempotency cleanup: %w", ctx.Err())
+	}
+}
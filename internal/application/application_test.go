@@ -0,0 +1,34 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/config"
+)
+
+func TestMetricsRegistration(t *testing.T) {
+	conf := config.Configuration{
+		DatabaseDSN:    "memory://",
+		PasswordHash:   "argon2id",
+		JWTEphemeral:   true,
+		CookieSameSite: "lax",
+	}
+	ctx := context.Background()
+
+	first, err := New(conf)
+	require.NoError(t, err)
+
+	// метрики хранилища уже принадлежат первому экземпляру
+	_, err = New(conf)
+	assert.Error(t, err)
+
+	// после закрытия метрики снимаются, следующий экземпляр отдаёт свои
+	require.NoError(t, first.Close(ctx))
+	second, err := New(conf)
+	require.NoError(t, err)
+	require.NoError(t, second.Close(ctx))
+}
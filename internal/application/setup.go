@@ -1,58 +1,83 @@
 package application
 
 import (
-	"crypto/sha256"
-	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
 	chimiddleware "github.com/go-chi/chi/middleware"
 
+	"github.com/eugene982/yp-gophermart/internal/config"
+	"github.com/eugene982/yp-gophermart/internal/handlers"
 	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/metrics"
 	"github.com/eugene982/yp-gophermart/internal/middleware"
-	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/clients"
 	"github.com/eugene982/yp-gophermart/internal/services/database"
-	"github.com/eugene982/yp-gophermart/internal/utils"
+	"github.com/eugene982/yp-gophermart/internal/services/loginguard"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
 
+	"github.com/eugene982/yp-gophermart/internal/handlers/api/accrual/webhook"
 	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/balance"
 	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/balance/withdraw"
 	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/login"
+	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/logout"
 	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/orders"
+	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/password"
 	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/register"
+	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/token"
 	"github.com/eugene982/yp-gophermart/internal/handlers/api/user/withdrawals"
+	"github.com/eugene982/yp-gophermart/internal/handlers/health"
 	"github.com/eugene982/yp-gophermart/internal/handlers/ping"
 )
 
-// Хеширования паролей
-func passworsHash(r model.LoginReqest) string {
-	h := sha256.New()
-	return fmt.Sprintf("%x",
-		h.Sum([]byte(r.Password+passwordSalt+r.Login)))
-}
+// Возвращает роутер, breaker может быть nil, если опрос системы начислений отключён
+func newRouter(db database.Database, breaker handlers.CircuitBreaker, hasher handlers.PasswordHasher,
+	tokens *sessions.Manager, tokenWriter handlers.TokenWriter, conf config.Configuration) http.Handler {
 
-// Возвращает роутер
-func newRouter(db database.Database) http.Handler {
+	// вход и смена пароля делят счётчики неудачных попыток
+	guard := loginguard.New(db, newLoginGuardOptions(conf))
 
 	r := chi.NewRouter()
 
+	r.Use(middleware.Tracing)                           // прослойка трассировки
+	r.Use(middleware.RequestID)                         // идентификатор запроса для логов
 	r.Use(middleware.Logger)                            // прослойка логирования
+	r.Use(middleware.Metrics)                           // прослойка метрик
 	r.Use(chimiddleware.Compress(3, "gzip", "deflate")) // прослойка сжатия
+	if conf.TrustProxy {
+		r.Use(chimiddleware.RealIP) // адрес клиента из заголовков прокси
+	}
 
 	// методы доступные без авторизации
 	r.Group(func(r chi.Router) {
 		r.Get("/ping", ping.NewPingHandler(db))
-		r.Post("/api/user/register", register.NewRegisterHandler(db, utils.HasherFunc(passworsHash)))
-		r.Post("/api/user/login", login.NewLoginHandler(db, utils.HasherFunc(passworsHash)))
+		r.Get("/health", health.NewHealthHandler(db, breaker))
+		r.Get("/metrics", metrics.Handler().ServeHTTP)
+		r.Post("/api/user/register", register.NewRegisterHandler(db, hasher, tokens, tokenWriter))
+		r.Post("/api/user/login", login.NewLoginHandler(db, hasher, guard, tokens, tokenWriter))
+		// токен доступа к этому времени может истечь
+		r.Post("/api/user/token/refresh", token.NewRefreshHandler(tokens, tokenWriter))
+
+		// уведомления системы начислений подписываются общим секретом
+		if conf.AccrualWebhookSecret != "" {
+			r.Post("/api/accrual/webhook", webhook.NewWebhookHandler(
+				[]byte(conf.AccrualWebhookSecret), clients.NewAccrualReceiver(db)))
+		}
 	})
 
 	// методы доступные с авторизацией
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.CookieAuth)
+		r.Use(middleware.TokenAuth(tokens))
+
+		r.Post("/api/user/logout", logout.NewLogoutHandler(tokens, tokenWriter))
+		r.Post("/api/user/password", password.NewPasswordHandler(db, hasher, guard, tokens, tokenWriter))
 
 		r.Post("/api/user/orders", orders.NewAddOrderHandler(db))
 		r.Get("/api/user/orders", orders.NewGetOrdersHandler(db))
 		r.Get("/api/user/balance", balance.NewBalanceHandler(db))
-		r.Post("/api/user/balance/withdraw", withdraw.NewWithdrawHandler(db))
+		r.With(middleware.Idempotency(db, time.Second*time.Duration(conf.IdempotencyKeyTTL))).
+			Post("/api/user/balance/withdraw", withdraw.NewWithdrawHandler(db))
 		r.Get("/api/user/withdrawals", withdrawals.NewWithdrawalsHandler(db))
 	})
 
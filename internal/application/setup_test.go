@@ -6,7 +6,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/eugene982/yp-gophermart/internal/config"
+	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/services/database/mocks"
+	"github.com/eugene982/yp-gophermart/internal/services/jwtkeys"
+	"github.com/eugene982/yp-gophermart/internal/services/passwords"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -35,7 +40,7 @@ func TestRouterMethods(t *testing.T) {
 	}
 
 	mockDB := mocks.NewDatabase(t)
-	router := newRouter(mockDB)
+	router := newRouter(mockDB, nil, newTestHasher(t), newTestSessions(t, mockDB), newTestTokenWriter(t), config.Configuration{})
 
 	for _, tcase := range tests {
 		t.Run(tcase.method, func(t *testing.T) {
@@ -56,3 +61,64 @@ func TestRouterMethods(t *testing.T) {
 		})
 	}
 }
+
+func TestRouterMetrics(t *testing.T) {
+	mockDB := mocks.NewDatabase(t)
+	router := newRouter(mockDB, nil, newTestHasher(t), newTestSessions(t, mockDB), newTestTokenWriter(t), config.Configuration{})
+
+	// запрос до сбора, чтобы в метриках был ряд по маршруту
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `gophermart_http_requests_total{method="GET",route="unmatched",status="404"}`)
+}
+
+func newTestHasher(t *testing.T) *passwords.Hasher {
+	hasher, err := passwords.NewHasher(passwords.Options{})
+	require.NoError(t, err)
+	return hasher
+}
+
+func newTestSessions(t *testing.T, store sessions.Store) *sessions.Manager {
+	keys, err := jwtkeys.Ephemeral()
+	require.NoError(t, err)
+	return sessions.New(keys, store, sessions.Options{})
+}
+
+func newTestTokenWriter(t *testing.T) *middleware.TokenWriter {
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	return tokenWriter
+}
+
+func TestLoadTokenKeys(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name    string
+		conf    config.Configuration
+		wantErr bool
+	}{
+		{"not configured", config.Configuration{}, true},
+		{"secret", config.Configuration{JWTSecret: secret}, false},
+		{"ephemeral", config.Configuration{JWTEphemeral: true}, false},
+		{"file and secret", config.Configuration{JWTKeysFile: "keys.json", JWTSecret: secret}, true},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			keys, err := loadTokenKeys(tcase.conf)
+			if tcase.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, keys.SigningKeyID())
+		})
+	}
+}
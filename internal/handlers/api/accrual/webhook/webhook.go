@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/utils"
+)
+
+const (
+	// подпись тела запроса: "sha256=" и HMAC-SHA256 в hex
+	SignatureHeader = "X-Accrual-Signature"
+	signaturePrefix = "sha256="
+
+	maxBodySize = 1 << 20
+)
+
+// Подпись тела уведомления общим секретом
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// уведомление системы начислений о результате расчёта по заказу
+func NewWebhookHandler(secret []byte, applier handlers.AccrualApplier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			logger.FromContext(r.Context()).Info("invalid body", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signature := r.Header.Get(SignatureHeader)
+		if !strings.HasPrefix(signature, signaturePrefix) ||
+			!hmac.Equal([]byte(signature), []byte(Sign(secret, body))) {
+			logger.FromContext(r.Context()).Info("invalid webhook signature")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var request model.AccrualResponse
+		if err = json.Unmarshal(body, &request); err != nil {
+			logger.FromContext(r.Context()).Info("bad reqest", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		order, err := utils.OrderNumberToInt(request.Order)
+		if err != nil {
+			logger.FromContext(r.Context()).Info("invalid order number", "err", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err = applier.ApplyAccrual(r.Context(), order, request); err != nil {
+			switch {
+			case handlers.IsNoContent(err):
+				logger.FromContext(r.Context()).Info("webhook order not found", "number", order)
+				http.Error(w, "order not found", http.StatusNotFound)
+			case errors.Is(err, model.ErrUnknownOrderStatus):
+				logger.FromContext(r.Context()).Info("webhook invalid status", "number", order, "status", request.Status)
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			case errors.Is(err, database.ErrInvalidTransition):
+				logger.FromContext(r.Context()).Info("webhook status conflict", "number", order, "err", err)
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				logger.FromContext(r.Context()).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/clients"
+	"github.com/eugene982/yp-gophermart/internal/services/database/memory"
+)
+
+func TestWebhookHandler(t *testing.T) {
+
+	secret := []byte("secret")
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 12345678903))
+
+	handler := NewWebhookHandler(secret, clients.NewAccrualReceiver(store))
+
+	// случаи выполняются по порядку на одном хранилище
+	tests := []struct {
+		name       string
+		body       string
+		signature  string
+		wantStatus int
+	}{
+		{
+			name:       "invalid signature",
+			body:       `{"order":"12345678903","status":"PROCESSED","accrual":10}`,
+			signature:  Sign([]byte("other"), []byte(`{"order":"12345678903","status":"PROCESSED","accrual":10}`)),
+			wantStatus: 401,
+		},
+		{
+			name:       "no signature",
+			body:       `{"order":"12345678903","status":"PROCESSED","accrual":10}`,
+			wantStatus: 401,
+		},
+		{
+			name:       "bad order",
+			body:       `{"order":"12345678900","status":"PROCESSED","accrual":10}`,
+			wantStatus: 422,
+		},
+		{
+			name:       "unknown order",
+			body:       `{"order":"79927398713","status":"PROCESSED","accrual":10}`,
+			wantStatus: 404,
+		},
+		{
+			name:       "unknown status",
+			body:       `{"order":"12345678903","status":"DONE"}`,
+			wantStatus: 422,
+		},
+		{
+			name:       "processing",
+			body:       `{"order":"12345678903","status":"PROCESSING"}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "processed",
+			body:       `{"order":"12345678903","status":"PROCESSED","accrual":10}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "repeated",
+			body:       `{"order":"12345678903","status":"PROCESSED","accrual":10}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "regression",
+			body:       `{"order":"12345678903","status":"PROCESSING"}`,
+			wantStatus: 409,
+		},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+
+			signature := tcase.signature
+			if signature == "" && tcase.wantStatus != 401 {
+				signature = Sign(secret, []byte(tcase.body))
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tcase.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set(SignatureHeader, signature)
+
+			handler(w, r)
+			assert.Equal(t, tcase.wantStatus, w.Code)
+		})
+	}
+
+	// начисление записано один раз
+	balance, err := store.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(1000), balance.Current)
+}
@@ -16,7 +16,7 @@ func NewBalanceHandler(reader handlers.BalanceReader) http.HandlerFunc {
 
 		userID, err := middleware.GetCookieUserID(r)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -24,19 +24,19 @@ func NewBalanceHandler(reader handlers.BalanceReader) http.HandlerFunc {
 		// получаем сведения о лояльности
 		balance, err := reader.ReadBalance(r.Context(), userID)
 		if err != nil && !handlers.IsNoContent(err) {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		response := model.BalanceResponse{
-			Current:   float32(balance.Current) / 100.0,
-			Withdrawn: float32(balance.Withdrawn) / 100.0,
+			Current:   balance.Current,
+			Withdrawn: balance.Withdrawn,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err = json.NewEncoder(w).Encode(response); err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -12,26 +12,21 @@ import (
 	"github.com/eugene982/yp-gophermart/internal/utils"
 )
 
-type WithdrawReadWtiter interface {
-	handlers.BalanceReader
-	handlers.WithdrawWriter
-}
-
 // запрос на списание средств
-func NewWithdrawHandler(rw WithdrawReadWtiter) http.HandlerFunc {
+func NewWithdrawHandler(writer handlers.WithdrawWriter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
 		contentType := r.Header.Get("Content-Type")
 		if !strings.Contains(contentType, "application/json") {
-			logger.Info("invalid header", "Content-Type", contentType)
+			logger.FromContext(r.Context()).Info("invalid header", "Content-Type", contentType)
 			http.Error(w, "invalid content-type", http.StatusBadRequest)
 			return
 		}
 
 		userID, err := middleware.GetCookieUserID(r)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -39,38 +34,37 @@ func NewWithdrawHandler(rw WithdrawReadWtiter) http.HandlerFunc {
 		var request model.WithdrawRequest
 		err = json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			logger.Info("bad reqest", "error", err)
+			logger.FromContext(r.Context()).Info("bad reqest", "error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		order, err := utils.OrderNumberToInt(request.Order)
 		if err != nil {
-			logger.Info("invalid order number", "err", err)
+			logger.FromContext(r.Context()).Info("invalid order number", "err", err)
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
 
-		// проверка наличия достаточного остатка
-		if balance, err := rw.ReadBalance(r.Context(), userID); err != nil {
-			if handlers.IsNoContent(err) {
-				logger.Info("payment required", "balance", err)
+		if request.Sum <= 0 {
+			logger.FromContext(r.Context()).Info("invalid withdraw sum", "sum", request.Sum)
+			http.Error(w, "invalid withdraw sum", http.StatusUnprocessableEntity)
+			return
+		}
+
+		// проверка остатка и списание выполняются хранилищем атомарно
+		if err = writer.Withdraw(r.Context(), userID, order, request.Sum); err != nil {
+			if handlers.IsInsufficientFunds(err) {
+				logger.FromContext(r.Context()).Info("payment required", "user_id", userID, "sum", request.Sum)
 				http.Error(w, "402 Payment required", http.StatusPaymentRequired)
+			} else if handlers.IsWriteConflict(err) {
+				logger.FromContext(r.Context()).Info("withdraw order conflict", "number", order)
+				http.Error(w, "409 Conflict", http.StatusConflict) // по номеру уже было списание
 			} else {
-				logger.Error(err)
+				logger.FromContext(r.Context()).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 			return
-		} else if balance.Current < int(request.Sum*100) {
-			logger.Info("payment required", "balance", balance)
-			http.Error(w, "402 Payment required", http.StatusPaymentRequired)
-			return
-		}
-
-		if err = rw.WriteWithdraw(r.Context(), userID, order, int(request.Sum*100)); err != nil {
-			logger.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
 		}
 		w.WriteHeader(http.StatusOK)
 	}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
 	"github.com/eugene982/yp-gophermart/internal/services/database/mocks"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,7 +16,7 @@ func TestWithdrawHandler(t *testing.T) {
 
 	userID := "user"
 	orderID := int64(12345678903)
-	points := 50505
+	points := model.Points(50505)
 
 	type request struct {
 		contentType string
@@ -42,6 +43,14 @@ func TestWithdrawHandler(t *testing.T) {
 			},
 			wantStatus: 402,
 		},
+		{
+			name: "order conflict",
+			request: request{
+				"application/json",
+				`{"order":"12345678903", "sum":1}`,
+			},
+			wantStatus: 409,
+		},
 		{
 			name: "bad order",
 			request: request{
@@ -50,6 +59,14 @@ func TestWithdrawHandler(t *testing.T) {
 			},
 			wantStatus: 422,
 		},
+		{
+			name: "negative sum",
+			request: request{
+				"application/json",
+				`{"order":"12345678903", "sum":-10}`,
+			},
+			wantStatus: 422,
+		},
 	}
 	for _, tcase := range tests {
 		t.Run(tcase.name, func(t *testing.T) {
@@ -61,22 +78,19 @@ func TestWithdrawHandler(t *testing.T) {
 			r.Header.Set("Content-Type", tcase.request.contentType)
 			r = middleware.RequestWithUserID(r, userID)
 
-			if tcase.wantStatus != 422 {
-				mockDB.On("ReadBalance", r.Context(), userID).
-					Once().
-					Return(
-						model.BalanceInfo{
-							UserID:    userID,
-							Current:   points,
-							Withdrawn: 0,
-						},
-						nil)
-			}
-
-			if tcase.wantStatus == 200 {
-				mockDB.On("WriteWithdraw", r.Context(), userID, orderID, points).
+			switch tcase.wantStatus {
+			case 200:
+				mockDB.On("Withdraw", r.Context(), userID, orderID, points).
 					Once().
 					Return(nil)
+			case 402:
+				mockDB.On("Withdraw", r.Context(), userID, orderID, model.Points(60000)).
+					Once().
+					Return(database.ErrInsufficientFunds)
+			case 409:
+				mockDB.On("Withdraw", r.Context(), userID, orderID, model.Points(100)).
+					Once().
+					Return(database.ErrWriteConflict)
 			}
 
 			NewWithdrawHandler(mockDB).ServeHTTP(w, r)
@@ -4,21 +4,45 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/eugene982/yp-gophermart/internal/handlers"
 	"github.com/eugene982/yp-gophermart/internal/logger"
-	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/model"
 )
 
-// // вход пользователя
-func NewLoginHandler(reader handlers.UserReader, hasher handlers.PasswordHasher) http.HandlerFunc {
+type UserReadUpdater interface {
+	handlers.UserReader
+	handlers.PasswordUpdater
+}
+
+// одинаковый ответ для неизвестного логина и неверного пароля,
+// чтобы по нему нельзя было узнать, есть ли пользователь
+const errInvalidCredentials = "invalid login or password"
+
+// вход пользователя
+func NewLoginHandler(users UserReadUpdater, hasher handlers.PasswordHasher, guard handlers.LoginGuard,
+	sessions handlers.SessionStarter, tokenWriter handlers.TokenWriter) http.HandlerFunc {
+
+	// хеш для проверки пароля неизвестного пользователя,
+	// чтобы ответ занимал столько же времени, сколько для известного
+	var (
+		dummyOnce sync.Once
+		dummyHash string
+	)
+	verifyDummy := func(request model.LoginReqest) {
+		dummyOnce.Do(func() {
+			dummyHash, _ = hasher.Hash(model.LoginReqest{Login: "dummy", Password: "dummy password"})
+		})
+		hasher.Verify(request, dummyHash)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
 		contentType := r.Header.Get("Content-type")
 		if !strings.Contains(contentType, "application/json") {
-			logger.Info("invalid header", "Content-Type", contentType)
+			logger.FromContext(r.Context()).Info("invalid header", "Content-Type", contentType)
 			http.Error(w, "invalid content-type", http.StatusBadRequest)
 			return
 		}
@@ -26,44 +50,91 @@ func NewLoginHandler(reader handlers.UserReader, hasher handlers.PasswordHasher)
 		var request model.LoginReqest
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			logger.Info("bad reqest", "error", err)
+			logger.FromContext(r.Context()).Info("bad reqest", "error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		if ok, err := request.IsValid(); !ok {
-			logger.Info("bad request", "error", err)
+			logger.FromContext(r.Context()).Info("bad request", "error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		// попытка засчитывается до проверки пароля, при переборе вход откладывается
+		ip := handlers.ClientIP(r)
+		retryAfter, err := guard.BeginLogin(r.Context(), request.Login, ip)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if retryAfter > 0 {
+			logger.FromContext(r.Context()).Info("login throttled",
+				"login", request.Login, "retry_after", retryAfter)
+			handlers.TooManyAttempts(w, retryAfter)
+			return
+		}
+
 		// получаем данные пользователя и проверяем пароль
-		userInfo, err := reader.ReadUser(r.Context(), request.Login)
+		userInfo, err := users.ReadUser(r.Context(), request.Login)
+		if err != nil && !handlers.IsNoContent(err) {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var ok, rehash bool
 		if err != nil {
-			if handlers.IsNoContent(err) {
-				logger.Info("user not found", "login", request.Login)
-				http.Error(w, "user not found", http.StatusUnauthorized)
-			} else {
-				logger.Error(err)
+			verifyDummy(request)
+			logger.FromContext(r.Context()).Info("user not found", "login", request.Login)
+		} else {
+			ok, rehash, err = hasher.Verify(request, userInfo.PasswordHash)
+			if err != nil {
+				logger.FromContext(r.Context()).Error(err, "login", request.Login)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				logger.FromContext(r.Context()).Info("password does not match", "login", request.Login)
 			}
-			return
 		}
 
-		if userInfo.PasswordHash != hasher.Hash(request) {
-			logger.Info("password does not match",
-				"login", request.Login)
-			http.Error(w, "password does not match", http.StatusUnauthorized)
+		if !ok {
+			if err = guard.LoginFailed(r.Context(), request.Login, ip); err != nil {
+				logger.FromContext(r.Context()).Error(err)
+			}
+			http.Error(w, errInvalidCredentials, http.StatusUnauthorized)
 			return
 		}
+		if err = guard.LoginSucceeded(r.Context(), request.Login, ip); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+		}
 
-		// запоминаем пользователя в куках
-		err = middleware.SetCookieUserID(request.Login, w)
+		// хеш устаревшего формата заменяется, пока известен пароль,
+		// неудача замены не мешает входу
+		if rehash {
+			hash, err := hasher.Hash(request)
+			if err == nil {
+				err = users.UpdatePasswordHash(r.Context(), userInfo.UserID, userInfo.PasswordHash, hash)
+			}
+			if err != nil {
+				logger.FromContext(r.Context()).Warn("rehash password", "login", request.Login, "error", err)
+			} else {
+				logger.FromContext(r.Context()).Info("password rehashed", "login", request.Login)
+			}
+		}
+
+		// новая сессия
+		tokens, err := sessions.StartSession(r.Context(), userInfo.UserID)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
+		// токены в куках для браузера и в теле для клиентов с Bearer
+		if err = tokenWriter.WriteTokens(w, tokens); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+		}
 	}
 }
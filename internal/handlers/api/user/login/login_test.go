@@ -6,21 +6,67 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
 	"github.com/eugene982/yp-gophermart/internal/services/database/mocks"
-	"github.com/eugene982/yp-gophermart/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+// хешер без хеширования, rehash - хеш требует замены
+type plainHasher struct {
+	rehash bool
+}
+
+func (h plainHasher) Hash(lr model.LoginReqest) (string, error) {
+	return "new:" + lr.Password, nil
+}
+
+func (h plainHasher) Verify(lr model.LoginReqest, hash string) (bool, bool, error) {
+	ok := lr.Password == hash
+	return ok, ok && h.rehash, nil
+}
+
+// выдача фиксированных токенов
+type fakeSessions struct{}
+
+func (fakeSessions) StartSession(ctx context.Context, userID string) (model.Tokens, error) {
+	return model.Tokens{AccessToken: "access-" + userID, RefreshToken: "refresh-" + userID}, nil
+}
+
+// учёт попыток входа в памяти, retryAfter - ответ на начало попытки
+type fakeGuard struct {
+	retryAfter time.Duration
+	failed     []string
+	succeeded  []string
+}
+
+func (g *fakeGuard) BeginLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	return g.retryAfter, nil
+}
+
+func (g *fakeGuard) LoginFailed(ctx context.Context, login, ip string) error {
+	g.failed = append(g.failed, login+"@"+ip)
+	return nil
+}
+
+func (g *fakeGuard) LoginSucceeded(ctx context.Context, login, ip string) error {
+	g.succeeded = append(g.succeeded, login+"@"+ip)
+	return nil
+}
+
 func TestLogin(t *testing.T) {
 
-	hasher := utils.HasherFunc(func(lr model.LoginReqest) string {
-		return lr.Password
-	})
+	hasher := plainHasher{}
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
 
 	db := mocks.NewDatabase(t)
-	call := db.On("ReadUser", context.TODO(), "user")
+	call := db.On("ReadUser", context.Background(), "user")
 
 	type request struct {
 		contentType string
@@ -71,6 +117,14 @@ func TestLogin(t *testing.T) {
 			},
 			wantStatus: 401,
 		},
+		{
+			name: "user not found",
+			request: request{
+				"application/json",
+				`{"login":"user", "password":"password"}`,
+			},
+			wantStatus: 401,
+		},
 		{
 			name: "internal error",
 			request: request{
@@ -83,9 +137,11 @@ func TestLogin(t *testing.T) {
 	for _, tcase := range tests {
 		t.Run(tcase.name, func(t *testing.T) {
 
-			switch tcase.wantStatus {
-			case 500:
+			switch {
+			case tcase.wantStatus == 500:
 				call.Return(model.UserInfo{}, fmt.Errorf("mock write error"))
+			case tcase.name == "user not found":
+				call.Return(model.UserInfo{}, database.ErrNoContent)
 			default:
 				call.Return(model.UserInfo{UserID: "user", PasswordHash: "password"}, nil)
 			}
@@ -95,8 +151,75 @@ func TestLogin(t *testing.T) {
 				strings.NewReader(tcase.request.body))
 			r.Header.Set("Content-Type", tcase.request.contentType)
 
-			NewLoginHandler(db, hasher).ServeHTTP(w, r)
+			guard := &fakeGuard{}
+			NewLoginHandler(db, hasher, guard, fakeSessions{}, tokenWriter).ServeHTTP(w, r)
 			assert.Equal(t, tcase.wantStatus, w.Code)
+			if tcase.wantStatus == 401 {
+				// неизвестный логин не отличить от неверного пароля
+				assert.Equal(t, errInvalidCredentials+"\n", w.Body.String())
+				assert.Equal(t, []string{"user@192.0.2.1"}, guard.failed)
+			}
+			if tcase.wantStatus == 200 {
+				assert.Equal(t, []string{"user@192.0.2.1"}, guard.succeeded)
+				cookies := w.Result().Cookies()
+				require.Len(t, cookies, 2)
+				assert.Equal(t, "access-user", cookies[0].Value)
+				assert.Equal(t, "refresh-user", cookies[1].Value)
+				assert.Contains(t, w.Body.String(), `"access_token":"access-user"`)
+			}
 		})
 	}
 }
+
+func TestLoginRehash(t *testing.T) {
+
+	hasher := plainHasher{rehash: true}
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	body := `{"login":"user","password":"password"}`
+
+	tests := []struct {
+		name      string
+		updateErr error
+	}{
+		{"updated", nil},
+		{"update error", fmt.Errorf("mock update error")},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			db := mocks.NewDatabase(t)
+			db.On("ReadUser", mock.Anything, "user").
+				Return(model.UserInfo{UserID: "user", PasswordHash: "password"}, nil)
+			db.On("UpdatePasswordHash", mock.Anything, "user", "password", "new:password").
+				Return(tcase.updateErr)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+
+			NewLoginHandler(db, hasher, &fakeGuard{}, fakeSessions{}, tokenWriter).ServeHTTP(w, r)
+			// ошибка замены хеша не мешает входу
+			assert.Equal(t, 200, w.Code)
+		})
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+
+	// пароль не проверяется, пока вход заблокирован
+	db := mocks.NewDatabase(t)
+	guard := &fakeGuard{retryAfter: 1500 * time.Millisecond}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"login":"user","password":"password"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	NewLoginHandler(db, plainHasher{}, guard, fakeSessions{}, tokenWriter).ServeHTTP(w, r)
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Empty(t, guard.failed)
+	assert.Empty(t, guard.succeeded)
+}
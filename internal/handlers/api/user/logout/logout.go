@@ -0,0 +1,31 @@
+package logout
+
+import (
+	"net/http"
+
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/middleware"
+)
+
+// выход пользователя, токены текущей сессии больше не принимаются
+func NewLogoutHandler(sessions handlers.SessionEnder, tokenWriter handlers.TokenWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		sessionID, err := middleware.GetSessionID(r)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err = sessions.EndSession(r.Context(), sessionID); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tokenWriter.ClearTokenCookies(w)
+		w.WriteHeader(http.StatusOK)
+	}
+}
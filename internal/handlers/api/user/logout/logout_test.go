@@ -0,0 +1,78 @@
+package logout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/middleware"
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// завершение сессии с заданной ошибкой
+type fakeSessions struct {
+	ended []string
+	err   error
+}
+
+func (f *fakeSessions) EndSession(ctx context.Context, sessionID string) error {
+	f.ended = append(f.ended, sessionID)
+	return f.err
+}
+
+// проверка любого токена
+type anyVerifier struct{}
+
+func (anyVerifier) VerifyAccessToken(ctx context.Context, token string) (model.TokenClaims, error) {
+	return model.TokenClaims{UserID: "user", SessionID: token}, nil
+}
+
+func TestLogout(t *testing.T) {
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{"ok", nil, http.StatusOK},
+		{"internal error", fmt.Errorf("mock storage error"), http.StatusInternalServerError},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			sessions := &fakeSessions{err: tcase.err}
+			// идентификатор сессии в контекст кладёт прослойка авторизации
+			handler := middleware.TokenAuth(anyVerifier{})(NewLogoutHandler(sessions, tokenWriter))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/user/logout", nil)
+			r.AddCookie(&http.Cookie{Name: middleware.AccessCookieName, Value: "session"})
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tcase.wantStatus, w.Code)
+			assert.Equal(t, []string{"session"}, sessions.ended)
+
+			if tcase.wantStatus == http.StatusOK {
+				cookies := w.Result().Cookies()
+				require.Len(t, cookies, 2)
+				assert.Negative(t, cookies[0].MaxAge)
+				assert.Negative(t, cookies[1].MaxAge)
+			}
+		})
+	}
+}
+
+func TestLogoutWithoutSession(t *testing.T) {
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/user/logout", nil)
+
+	NewLogoutHandler(&fakeSessions{}, tokenWriter).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
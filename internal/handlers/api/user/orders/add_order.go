@@ -5,9 +5,12 @@ import (
 	"net/http"
 	"strings"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/eugene982/yp-gophermart/internal/handlers"
 	"github.com/eugene982/yp-gophermart/internal/logger"
 	"github.com/eugene982/yp-gophermart/internal/middleware"
+	"github.com/eugene982/yp-gophermart/internal/tracing"
 	"github.com/eugene982/yp-gophermart/internal/utils"
 )
 
@@ -24,14 +27,14 @@ func NewAddOrderHandler(orw OrderReadWriter) http.HandlerFunc {
 
 		contentType := r.Header.Get("Content-type")
 		if !strings.Contains(contentType, "text/plain") {
-			logger.Info("invalid header", "Content-Type", contentType)
+			logger.FromContext(r.Context()).Info("invalid header", "Content-Type", contentType)
 			http.Error(w, "invalid content-type", http.StatusBadRequest)
 			return
 		}
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			logger.Info("invalid body", "err", err)
+			logger.FromContext(r.Context()).Info("invalid body", "err", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -39,30 +42,32 @@ func NewAddOrderHandler(orw OrderReadWriter) http.HandlerFunc {
 		// проверка корректности номера заказа
 		order, err := utils.OrderNumberToInt(string(body))
 		if err != nil {
-			logger.Info("invalid order number", "err", err)
+			logger.FromContext(r.Context()).Info("invalid order number", "err", err)
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
+		// по номеру заказа запрос связывается с его опросом в системе начислений
+		trace.SpanFromContext(r.Context()).SetAttributes(tracing.OrderIDKey.Int64(order))
 
 		userID, err := middleware.GetCookieUserID(r)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		if err = orw.WriteNewOrder(r.Context(), userID, order); err != nil {
 			if !handlers.IsWriteConflict(err) {
-				logger.Error(err)
+				logger.FromContext(r.Context()).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			logger.Info("write order conflict", "error", err, "number", order)
+			logger.FromContext(r.Context()).Info("write order conflict", "error", err, "number", order)
 
 			// проверяем кому принадлежит номер
 			userOrders, err := orw.ReadOrders(r.Context(), userID, order)
 			if err != nil {
-				logger.Error(err)
+				logger.FromContext(r.Context()).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			} else if len(userOrders) == 0 {
 				http.Error(w, "409 Conflict", http.StatusConflict) // существует для другого пользователя
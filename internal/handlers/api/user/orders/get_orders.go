@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/eugene982/yp-gophermart/internal/handlers"
@@ -24,14 +23,14 @@ func NewGetOrdersHandler(reader OrderAcrualReader) http.HandlerFunc {
 
 		userID, err := middleware.GetCookieUserID(r)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		orders, err := reader.ReadOrders(r.Context(), userID)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -43,30 +42,34 @@ func NewGetOrdersHandler(reader OrderAcrualReader) http.HandlerFunc {
 		// получаем сведения о лояльности
 		operations, err := reader.ReadAccruals(r.Context(), userID)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		// сгрупируем по номеру
-		accruals := make(map[int64]int)
+		accruals := make(map[int64]model.Points)
 		for _, l := range operations {
-			accruals[l.OrderID] += l.Points
+			if accruals[l.OrderID], err = accruals[l.OrderID].Add(l.Points); err != nil {
+				logger.FromContext(r.Context()).Error(err, "order", l.OrderID)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 		}
 
 		response := make([]model.OrderResponse, len(orders))
 		for i, o := range orders {
 			response[i] = model.OrderResponse{
 				Number:     strconv.FormatInt(o.OrderID, 10),
-				Status:     strings.ToUpper(o.Status),
-				Accrual:    float32(accruals[o.OrderID]) / 100,
+				Status:     o.Status.Public(),
+				Accrual:    accruals[o.OrderID],
 				UploadedAt: o.UploadedAt.Format(time.RFC3339),
 			}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err = json.NewEncoder(w).Encode(response); err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
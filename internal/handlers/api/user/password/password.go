@@ -0,0 +1,140 @@
+package password
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/middleware"
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+type UserReadUpdater interface {
+	handlers.UserReader
+	handlers.PasswordUpdater
+}
+
+type SessionRestarter interface {
+	handlers.UserSessionsEnder
+	handlers.SessionStarter
+}
+
+// смена пароля, все сессии пользователя завершаются,
+// текущему клиенту выдаётся новая. Проверка старого пароля
+// ограничивается так же, как вход
+func NewPasswordHandler(users UserReadUpdater, hasher handlers.PasswordHasher, guard handlers.LoginGuard,
+	sessions SessionRestarter, tokenWriter handlers.TokenWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		contentType := r.Header.Get("Content-type")
+		if !strings.Contains(contentType, "application/json") {
+			logger.FromContext(r.Context()).Info("invalid header", "Content-Type", contentType)
+			http.Error(w, "invalid content-type", http.StatusBadRequest)
+			return
+		}
+
+		var request model.PasswordChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			logger.FromContext(r.Context()).Info("bad reqest", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if ok, err := request.IsValid(); !ok {
+			logger.FromContext(r.Context()).Info("bad request", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID, err := middleware.GetCookieUserID(r)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// с украденным токеном нельзя подбирать текущий пароль:
+		// попытки засчитываются в тот же счётчик, что и вход
+		ip := handlers.ClientIP(r)
+		retryAfter, err := guard.BeginLogin(r.Context(), userID, ip)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if retryAfter > 0 {
+			logger.FromContext(r.Context()).Info("password change throttled", "retry_after", retryAfter)
+			handlers.TooManyAttempts(w, retryAfter)
+			return
+		}
+
+		userInfo, err := users.ReadUser(r.Context(), userID)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// старый пароль подтверждает, что токеном пользуется владелец
+		ok, _, err := hasher.Verify(model.LoginReqest{Login: userID, Password: request.OldPassword},
+			userInfo.PasswordHash)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			logger.FromContext(r.Context()).Info("password does not match")
+			if err = guard.LoginFailed(r.Context(), userID, ip); err != nil {
+				logger.FromContext(r.Context()).Error(err)
+			}
+			http.Error(w, "password does not match", http.StatusUnauthorized)
+			return
+		}
+		if err = guard.LoginSucceeded(r.Context(), userID, ip); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+		}
+
+		hash, err := hasher.Hash(model.LoginReqest{Login: userID, Password: request.NewPassword})
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = users.UpdatePasswordHash(r.Context(), userID, userInfo.PasswordHash, hash)
+		if err != nil {
+			if handlers.IsNoContent(err) {
+				// пароль успели сменить другим запросом
+				logger.FromContext(r.Context()).Info("password change conflict")
+				http.Error(w, "409 Conflict", http.StatusConflict)
+			} else {
+				logger.FromContext(r.Context()).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// токены всех сессий, в том числе украденные, больше не действуют
+		if err = sessions.EndUserSessions(r.Context(), userID); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.FromContext(r.Context()).Info("password changed, sessions revoked")
+
+		tokens, err := sessions.StartSession(r.Context(), userID)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// токены в куках для браузера и в теле для клиентов с Bearer
+		if err = tokenWriter.WriteTokens(w, tokens); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+		}
+	}
+}
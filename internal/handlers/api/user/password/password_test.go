@@ -0,0 +1,170 @@
+package password
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/middleware"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/services/database/mocks"
+)
+
+// хешер без хеширования
+type plainHasher struct{}
+
+func (plainHasher) Hash(lr model.LoginReqest) (string, error) {
+	return lr.Password, nil
+}
+
+func (plainHasher) Verify(lr model.LoginReqest, hash string) (bool, bool, error) {
+	return lr.Password == hash, false, nil
+}
+
+// сессии с заданной ошибкой завершения
+type fakeSessions struct {
+	ended []string
+	err   error
+}
+
+func (f *fakeSessions) EndUserSessions(ctx context.Context, userID string) error {
+	f.ended = append(f.ended, userID)
+	return f.err
+}
+
+func (f *fakeSessions) StartSession(ctx context.Context, userID string) (model.Tokens, error) {
+	return model.Tokens{AccessToken: "access-" + userID, RefreshToken: "refresh-" + userID}, nil
+}
+
+// учёт попыток в памяти, retryAfter - ответ на начало попытки
+type fakeGuard struct {
+	retryAfter time.Duration
+	failed     []string
+	succeeded  []string
+}
+
+func (g *fakeGuard) BeginLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	return g.retryAfter, nil
+}
+
+func (g *fakeGuard) LoginFailed(ctx context.Context, login, ip string) error {
+	g.failed = append(g.failed, login+"@"+ip)
+	return nil
+}
+
+func (g *fakeGuard) LoginSucceeded(ctx context.Context, login, ip string) error {
+	g.succeeded = append(g.succeeded, login+"@"+ip)
+	return nil
+}
+
+func TestPassword(t *testing.T) {
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	type request struct {
+		contentType string
+		body        string
+	}
+	tests := []struct {
+		name       string
+		request    request
+		updateErr  error
+		endErr     error
+		retryAfter time.Duration
+		wantStatus int
+		wantEnded  bool
+		wantFailed bool
+	}{
+		{
+			name:       "ok",
+			request:    request{"application/json", `{"old_password":"old","new_password":"new"}`},
+			wantStatus: http.StatusOK,
+			wantEnded:  true,
+		},
+		{
+			name:       "bad content-type",
+			request:    request{"text/plain", `{"old_password":"old","new_password":"new"}`},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "bad body",
+			request:    request{"application/json", `{"old_password":`},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "empty new password",
+			request:    request{"application/json", `{"old_password":"old","new_password":" "}`},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong old password",
+			request:    request{"application/json", `{"old_password":"wrong","new_password":"new"}`},
+			wantStatus: http.StatusUnauthorized,
+			wantFailed: true,
+		},
+		{
+			name:       "throttled",
+			request:    request{"application/json", `{"old_password":"old","new_password":"new"}`},
+			retryAfter: time.Second,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "changed concurrently",
+			request:    request{"application/json", `{"old_password":"old","new_password":"new"}`},
+			updateErr:  database.ErrNoContent,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "revoke error",
+			request:    request{"application/json", `{"old_password":"old","new_password":"new"}`},
+			endErr:     fmt.Errorf("mock storage error"),
+			wantStatus: http.StatusInternalServerError,
+			wantEnded:  true,
+		},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			db := mocks.NewDatabase(t)
+			db.On("ReadUser", mock.Anything, "user").
+				Return(model.UserInfo{UserID: "user", PasswordHash: "old"}, nil).Maybe()
+			db.On("UpdatePasswordHash", mock.Anything, "user", "old", "new").
+				Return(tcase.updateErr).Maybe()
+			sessions := &fakeSessions{err: tcase.endErr}
+			guard := &fakeGuard{retryAfter: tcase.retryAfter}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/user/password",
+				strings.NewReader(tcase.request.body))
+			r.Header.Set("Content-Type", tcase.request.contentType)
+			r = middleware.RequestWithUserID(r, "user")
+
+			NewPasswordHandler(db, plainHasher{}, guard, sessions, tokenWriter).ServeHTTP(w, r)
+			assert.Equal(t, tcase.wantStatus, w.Code)
+
+			if tcase.wantFailed {
+				assert.Equal(t, []string{"user@192.0.2.1"}, guard.failed)
+			} else {
+				assert.Empty(t, guard.failed)
+			}
+
+			if tcase.wantEnded {
+				assert.Equal(t, []string{"user"}, sessions.ended)
+			} else {
+				assert.Empty(t, sessions.ended)
+			}
+			if tcase.wantStatus == http.StatusOK {
+				cookies := w.Result().Cookies()
+				require.Len(t, cookies, 2)
+				assert.Equal(t, "access-user", cookies[0].Value)
+			}
+		})
+	}
+}
@@ -7,19 +7,19 @@ import (
 
 	"github.com/eugene982/yp-gophermart/internal/handlers"
 	"github.com/eugene982/yp-gophermart/internal/logger"
-	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/model"
 )
 
 // регистрация пользователя
-func NewRegisterHandler(writer handlers.UserWriter, hasher handlers.PasswordHasher) http.HandlerFunc {
+func NewRegisterHandler(writer handlers.UserWriter, hasher handlers.PasswordHasher,
+	sessions handlers.SessionStarter, tokenWriter handlers.TokenWriter) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
 		contentType := r.Header.Get("Content-Type")
 		if !strings.Contains(contentType, "application/json") {
-			logger.Info("invalid header", "Content-Type", contentType)
+			logger.FromContext(r.Context()).Info("invalid header", "Content-Type", contentType)
 			http.Error(w, "invalid content-type", http.StatusBadRequest)
 			return
 		}
@@ -27,44 +27,53 @@ func NewRegisterHandler(writer handlers.UserWriter, hasher handlers.PasswordHash
 		var request model.LoginReqest
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
-			logger.Info("bad reqest", "error", err)
+			logger.FromContext(r.Context()).Info("bad reqest", "error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		if ok, err := request.IsValid(); !ok {
-			logger.Info("bad request", "error", err)
+			logger.FromContext(r.Context()).Info("bad request", "error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		// хешируем пароли чтоб не хранить их в открытом виде
+		hash, err := hasher.Hash(request)
+		if err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		userInfo := model.UserInfo{
 			UserID:       request.Login,
-			PasswordHash: hasher.Hash(request),
+			PasswordHash: hash,
 		}
 
 		err = writer.WriteUser(r.Context(), userInfo)
 		if err != nil {
 			if handlers.IsWriteConflict(err) {
-				logger.Info("user conflict",
+				logger.FromContext(r.Context()).Info("user conflict",
 					"error", err,
 					"login", request.Login)
 				http.Error(w, "user conflict", http.StatusConflict)
 			} else {
-				logger.Error(err)
+				logger.FromContext(r.Context()).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 			return
 		}
 
-		// запоминаем пользователя в куках
-		err = middleware.SetCookieUserID(request.Login, w)
+		// новая сессия
+		tokens, err := sessions.StartSession(r.Context(), userInfo.UserID)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
+		// токены в куках для браузера и в теле для клиентов с Bearer
+		if err = tokenWriter.WriteTokens(w, tokens); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+		}
 	}
 }
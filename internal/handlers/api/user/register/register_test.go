@@ -7,21 +7,40 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/eugene982/yp-gophermart/internal/middleware"
 	"github.com/eugene982/yp-gophermart/internal/model"
 	"github.com/eugene982/yp-gophermart/internal/services/database"
 	"github.com/eugene982/yp-gophermart/internal/services/database/mocks"
-	"github.com/eugene982/yp-gophermart/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// хешер без хеширования
+type plainHasher struct{}
+
+func (plainHasher) Hash(lr model.LoginReqest) (string, error) {
+	return lr.Password, nil
+}
+
+func (plainHasher) Verify(lr model.LoginReqest, hash string) (bool, bool, error) {
+	return lr.Password == hash, false, nil
+}
+
+// выдача фиксированных токенов
+type fakeSessions struct{}
+
+func (fakeSessions) StartSession(ctx context.Context, userID string) (model.Tokens, error) {
+	return model.Tokens{AccessToken: "access-" + userID, RefreshToken: "refresh-" + userID}, nil
+}
+
 func TestRegister(t *testing.T) {
 
-	hasher := utils.HasherFunc(func(lr model.LoginReqest) string {
-		return lr.Password
-	})
+	hasher := plainHasher{}
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
 
 	db := mocks.NewDatabase(t)
-	call := db.On("WriteUser", context.TODO(), model.UserInfo{UserID: "user", PasswordHash: "password"})
+	call := db.On("WriteUser", context.Background(), model.UserInfo{UserID: "user", PasswordHash: "password"})
 
 	type request struct {
 		contentType string
@@ -98,8 +117,14 @@ func TestRegister(t *testing.T) {
 				strings.NewReader(tcase.request.body))
 			r.Header.Set("Content-Type", tcase.request.contentType)
 
-			NewRegisterHandler(db, hasher).ServeHTTP(w, r)
+			NewRegisterHandler(db, hasher, fakeSessions{}, tokenWriter).ServeHTTP(w, r)
 			assert.Equal(t, tcase.wantStatus, w.Code)
+			if tcase.wantStatus == 200 {
+				cookies := w.Result().Cookies()
+				require.Len(t, cookies, 2)
+				assert.Equal(t, "access-user", cookies[0].Value)
+				assert.Equal(t, "refresh-user", cookies[1].Value)
+			}
 		})
 	}
 }
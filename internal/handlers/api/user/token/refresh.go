@@ -0,0 +1,64 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/middleware"
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// обновление токенов сессии. Токен обновления берётся из тела запроса
+// {"refresh_token": "..."}, тогда новые токены возвращаются в теле ответа,
+// или из куки, тогда новые токены только в куках и скриптам страницы недоступны
+func NewRefreshHandler(sessions handlers.SessionRefresher, tokenWriter handlers.TokenWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var refreshToken string
+		fromBody := strings.Contains(r.Header.Get("Content-type"), "application/json")
+		if fromBody {
+			var request model.RefreshRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				logger.FromContext(r.Context()).Info("bad reqest", "error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			refreshToken = request.RefreshToken
+		} else {
+			refreshToken = middleware.GetRefreshToken(r)
+		}
+
+		if refreshToken == "" {
+			logger.FromContext(r.Context()).Info("refresh token not found")
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tokens, err := sessions.RefreshSession(r.Context(), refreshToken)
+		if err != nil {
+			if handlers.IsInvalidToken(err) {
+				// повтор заменённого токена - возможная утечка
+				logger.FromContext(r.Context()).Warn("invalid refresh token", "error", err)
+				tokenWriter.ClearTokenCookies(w)
+				http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			} else {
+				logger.FromContext(r.Context()).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if fromBody {
+			if err = tokenWriter.WriteTokens(w, tokens); err != nil {
+				logger.FromContext(r.Context()).Error(err)
+			}
+			return
+		}
+		tokenWriter.SetTokenCookies(w, tokens)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,110 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/middleware"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
+)
+
+// обновление по таблице токенов
+type fakeRefresher map[string]error
+
+func (f fakeRefresher) RefreshSession(ctx context.Context, refreshToken string) (model.Tokens, error) {
+	if err := f[refreshToken]; err != nil {
+		return model.Tokens{}, err
+	}
+	return model.Tokens{AccessToken: "new-access", RefreshToken: "new-refresh"}, nil
+}
+
+func TestRefresh(t *testing.T) {
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	refresher := fakeRefresher{
+		"reused": sessions.ErrTokenReused,
+		"broken": fmt.Errorf("mock storage error"),
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"ok", "valid", http.StatusOK},
+		{"no cookie", "", http.StatusUnauthorized},
+		{"reused", "reused", http.StatusUnauthorized},
+		{"internal error", "broken", http.StatusInternalServerError},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", nil)
+			if tcase.token != "" {
+				r.AddCookie(&http.Cookie{Name: middleware.RefreshCookieName, Value: tcase.token})
+			}
+			w := httptest.NewRecorder()
+
+			NewRefreshHandler(refresher, tokenWriter).ServeHTTP(w, r)
+			assert.Equal(t, tcase.wantStatus, w.Code)
+
+			cookies := w.Result().Cookies()
+			switch tcase.name {
+			case "ok":
+				require.Len(t, cookies, 2)
+				assert.Equal(t, "new-access", cookies[0].Value)
+				assert.Equal(t, "new-refresh", cookies[1].Value)
+				// из куки - токены только в куках
+				assert.Empty(t, w.Body.String())
+			case "reused":
+				// куки недействительной сессии удаляются
+				require.Len(t, cookies, 2)
+				assert.Negative(t, cookies[0].MaxAge)
+			}
+		})
+	}
+}
+
+func TestRefreshFromBody(t *testing.T) {
+	tokenWriter, err := middleware.NewTokenWriter(middleware.CookieOptions{})
+	require.NoError(t, err)
+	refresher := fakeRefresher{"reused": sessions.ErrTokenReused}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"ok", `{"refresh_token":"valid"}`, http.StatusOK},
+		{"bad body", `{"refresh_token":`, http.StatusBadRequest},
+		{"empty token", `{}`, http.StatusUnauthorized},
+		{"reused", `{"refresh_token":"reused"}`, http.StatusUnauthorized},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/token/refresh",
+				strings.NewReader(tcase.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			NewRefreshHandler(refresher, tokenWriter).ServeHTTP(w, r)
+			assert.Equal(t, tcase.wantStatus, w.Code)
+
+			if tcase.wantStatus == http.StatusOK {
+				// клиенту без кук новые токены нужны в теле
+				var body model.TokenResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+				assert.Equal(t, "new-access", body.AccessToken)
+				assert.Equal(t, "new-refresh", body.RefreshToken)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ func NewWithdrawalsHandler(reader handlers.WithdrawReader) http.HandlerFunc {
 
 		userID, err := middleware.GetCookieUserID(r)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -26,7 +26,7 @@ func NewWithdrawalsHandler(reader handlers.WithdrawReader) http.HandlerFunc {
 		// все данные лояльности
 		operations, err := reader.ReadWithdraws(r.Context(), userID)
 		if err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -39,14 +39,14 @@ func NewWithdrawalsHandler(reader handlers.WithdrawReader) http.HandlerFunc {
 		for i, l := range operations {
 			response[i] = model.WithdrawResponse{
 				Order:       strconv.FormatInt(l.OrderID, 10),
-				Sum:         float32(l.Points) / 100.0,
+				Sum:         l.Points,
 				ProcessedAt: l.UploadedAt.Format(time.RFC3339),
 			}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err = json.NewEncoder(w).Encode(response); err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -3,15 +3,25 @@ package handlers
 import (
 	"context"
 	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/eugene982/yp-gophermart/internal/model"
 	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
 )
 
 type Pinger interface {
 	Ping(context.Context) error
 }
 
+type CircuitBreaker interface {
+	BreakerState() string
+}
+
 type UserWriter interface {
 	WriteUser(ctx context.Context, data model.UserInfo) error
 }
@@ -20,6 +30,10 @@ type UserReader interface {
 	ReadUser(ctx context.Context, userID string) (model.UserInfo, error)
 }
 
+type PasswordUpdater interface {
+	UpdatePasswordHash(ctx context.Context, userID string, oldHash, newHash string) error
+}
+
 type OrderWriter interface {
 	WriteNewOrder(ctx context.Context, userID string, order int64) error
 }
@@ -37,15 +51,51 @@ type BalanceReader interface {
 }
 
 type WithdrawWriter interface {
-	WriteWithdraw(ctx context.Context, userID string, order int64, sum int) error
+	Withdraw(ctx context.Context, userID string, order int64, sum model.Points) error
 }
 
 type WithdrawReader interface {
 	ReadWithdraws(ctx context.Context, userID string) ([]model.OperationsInfo, error)
 }
 
+type AccrualApplier interface {
+	ApplyAccrual(ctx context.Context, order int64, resp model.AccrualResponse) error
+}
+
+type SessionStarter interface {
+	StartSession(ctx context.Context, userID string) (model.Tokens, error)
+}
+
+type SessionRefresher interface {
+	RefreshSession(ctx context.Context, refreshToken string) (model.Tokens, error)
+}
+
+type SessionEnder interface {
+	EndSession(ctx context.Context, sessionID string) error
+}
+
+type UserSessionsEnder interface {
+	EndUserSessions(ctx context.Context, userID string) error
+}
+
+type TokenWriter interface {
+	// токены в куках и в теле ответа
+	WriteTokens(w http.ResponseWriter, tokens model.Tokens) error
+	SetTokenCookies(w http.ResponseWriter, tokens model.Tokens)
+	ClearTokenCookies(w http.ResponseWriter)
+}
+
+type LoginGuard interface {
+	// retryAfter > 0 - попытки входа временно запрещены
+	BeginLogin(ctx context.Context, login, ip string) (retryAfter time.Duration, err error)
+	LoginFailed(ctx context.Context, login, ip string) error
+	LoginSucceeded(ctx context.Context, login, ip string) error
+}
+
 type PasswordHasher interface {
-	Hash(model.LoginReqest) string
+	Hash(model.LoginReqest) (string, error)
+	// rehash - пароль верный, но хеш нужно пересчитать с текущими параметрами
+	Verify(r model.LoginReqest, hash string) (ok bool, rehash bool, err error)
 }
 
 func IsWriteConflict(err error) bool {
@@ -61,3 +111,33 @@ func IsNoContent(err error) bool {
 	}
 	return errors.Is(err, database.ErrNoContent)
 }
+
+func IsInvalidToken(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, sessions.ErrInvalidToken)
+}
+
+func IsInsufficientFunds(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, database.ErrInsufficientFunds)
+}
+
+// Адрес клиента без порта. За доверенным прокси RemoteAddr
+// уже заменён адресом из заголовков
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Ответ на попытку входа, пока попытки запрещены LoginGuard
+func TooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "too many login attempts", http.StatusTooManyRequests)
+}
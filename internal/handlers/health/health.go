@@ -0,0 +1,43 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// Состояние сервиса и его зависимостей. Недоступная система начислений
+// не выводит сервис из работы, заказы будут обработаны позже
+func NewHealthHandler(pinger handlers.Pinger, breaker handlers.CircuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		response := model.HealthResponse{
+			Status:   "ok",
+			Database: "ok",
+		}
+		code := http.StatusOK
+
+		if err := pinger.Ping(r.Context()); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+			response.Status = "unavailable"
+			response.Database = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+
+		if breaker != nil {
+			response.Accrual = breaker.BreakerState()
+			if response.Accrual != "closed" && code == http.StatusOK {
+				response.Status = "degraded"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.FromContext(r.Context()).Error(err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eugene982/yp-gophermart/internal/handlers"
+)
+
+type mokPinger func(context.Context) error
+
+func (p mokPinger) Ping(ctx context.Context) error {
+	return p(ctx)
+}
+
+type mokBreaker string
+
+func (b mokBreaker) BreakerState() string {
+	return string(b)
+}
+
+func TestHealth(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		pingErr    error
+		breaker    handlers.CircuitBreaker
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "no accrual client",
+			wantStatus: 200,
+			wantBody:   `{"status":"ok","database":"ok"}`,
+		},
+		{
+			name:       "ok",
+			breaker:    mokBreaker("closed"),
+			wantStatus: 200,
+			wantBody:   `{"status":"ok","database":"ok","accrual":"closed"}`,
+		},
+		{
+			name:       "accrual open",
+			breaker:    mokBreaker("open"),
+			wantStatus: 200,
+			wantBody:   `{"status":"degraded","database":"ok","accrual":"open"}`,
+		},
+		{
+			name:       "database error",
+			pingErr:    fmt.Errorf("mock ping error"),
+			breaker:    mokBreaker("closed"),
+			wantStatus: 503,
+			wantBody:   `{"status":"unavailable","database":"mock ping error","accrual":"closed"}`,
+		},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/health", nil)
+
+			pinger := mokPinger(func(context.Context) error {
+				return tcase.pingErr
+			})
+
+			NewHealthHandler(pinger, tcase.breaker).ServeHTTP(w, r)
+			assert.Equal(t, tcase.wantStatus, w.Code)
+			assert.JSONEq(t, tcase.wantBody, w.Body.String())
+		})
+	}
+}
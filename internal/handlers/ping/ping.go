@@ -12,7 +12,7 @@ func NewPingHandler(pinger handlers.Pinger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if err := pinger.Ping(r.Context()); err != nil {
-			logger.Error(err)
+			logger.FromContext(r.Context()).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
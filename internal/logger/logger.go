@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"log"
 
 	"go.uber.org/zap"
@@ -34,42 +35,98 @@ func Initialize(level string) error {
 	return nil
 }
 
+// Логгер с полями, которые добавляются к каждому сообщению,
+// например идентификатор запроса. Нулевое значение пишет без полей
+type Logger struct {
+	fields []any
+}
+
+type contextKey struct{}
+
+// Логгер с дополнительными полями "ключ-значение"
+func (l Logger) With(a ...any) Logger {
+	fields := make([]any, 0, len(l.fields)+len(a))
+	fields = append(fields, l.fields...)
+	return Logger{append(fields, a...)}
+}
+
+// Контекст, логгер которого дополнен полями
+func ContextWith(ctx context.Context, a ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).With(a...))
+}
+
+// Логгер запроса из контекста, если его нет - логгер без полей
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(contextKey{}).(Logger); ok {
+		return l
+	}
+	return Logger{}
+}
+
+// поля логгера и сообщения
+func (l Logger) args(a []any) []any {
+	if len(l.fields) == 0 {
+		return a
+	}
+	return append(append(make([]any, 0, len(l.fields)+len(a)), l.fields...), a...)
+}
+
 // Отладочные сообщения
-func Debug(msg string, a ...any) {
+func (l Logger) Debug(msg string, a ...any) {
 	if zaplog != nil {
-		zaplog.Sugar().Debugw(msg, a...)
+		zaplog.Sugar().Debugw(msg, l.args(a)...)
 	} else {
-		stdLogPrint("DEBUG", msg, a...)
+		stdLogPrint("DEBUG", msg, l.args(a)...)
 	}
 }
 
 // Информационные сообщения
-func Info(msg string, a ...any) {
+func (l Logger) Info(msg string, a ...any) {
 	if zaplog != nil {
-		zaplog.Sugar().Infow(msg, a...)
+		zaplog.Sugar().Infow(msg, l.args(a)...)
 	} else {
-		stdLogPrint("INFO", msg, a...)
+		stdLogPrint("INFO", msg, l.args(a)...)
 	}
 }
 
 // Предупреждения
-func Warn(msg string, a ...any) {
+func (l Logger) Warn(msg string, a ...any) {
 	if zaplog != nil {
-		zaplog.Sugar().Warnw(msg, a...)
+		zaplog.Sugar().Warnw(msg, l.args(a)...)
 	} else {
-		stdLogPrint("WARN", msg, a...)
+		stdLogPrint("WARN", msg, l.args(a)...)
 	}
 }
 
 // Ошибки
-func Error(err error, a ...any) {
+func (l Logger) Error(err error, a ...any) {
 	if zaplog != nil {
-		zaplog.Sugar().Errorw(err.Error(), a...)
+		zaplog.Sugar().Errorw(err.Error(), l.args(a)...)
 	} else {
-		stdLogPrint("ERROR", err, a...)
+		stdLogPrint("ERROR", err, l.args(a)...)
 	}
 }
 
+// Отладочные сообщения
+func Debug(msg string, a ...any) {
+	Logger{}.Debug(msg, a...)
+}
+
+// Информационные сообщения
+func Info(msg string, a ...any) {
+	Logger{}.Info(msg, a...)
+}
+
+// Предупреждения
+func Warn(msg string, a ...any) {
+	Logger{}.Warn(msg, a...)
+}
+
+// Ошибки
+func Error(err error, a ...any) {
+	Logger{}.Error(err, a...)
+}
+
 // вывод в стандартный лог
 func stdLogPrint(level string, msg any, v ...any) {
 	p := []any{level, msg}
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	zaplog = zap.New(core)
+	t.Cleanup(func() { zaplog = nil })
+
+	ctx := ContextWith(context.Background(), "request_id", "r1")
+	ctx = ContextWith(ctx, "user_id", "u1", "route", stringer("/api/user/orders"))
+
+	FromContext(ctx).Info("with fields", "n", 1)
+	FromContext(context.Background()).Warn("without fields")
+	Info("global")
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 3)
+	assert.Equal(t, map[string]any{
+		"request_id": "r1",
+		"user_id":    "u1",
+		"route":      "/api/user/orders",
+		"n":          int64(1),
+	}, entries[0].ContextMap())
+	assert.Empty(t, entries[1].ContextMap())
+	assert.Empty(t, entries[2].ContextMap())
+}
+
+func TestWithDoesNotShareFields(t *testing.T) {
+	base := Logger{}.With("a", 1)
+	l1 := base.With("b", 2)
+	l2 := base.With("c", 3)
+	assert.Equal(t, []any{"a", 1, "b", 2}, l1.fields)
+	assert.Equal(t, []any{"a", 1, "c", 3}, l2.fields)
+}
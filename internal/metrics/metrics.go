@@ -0,0 +1,72 @@
+// Метрики сервиса в формате Prometheus
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+// Реестр метрик сервиса, отдаётся на /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// запросы к серверу по шаблону маршрута chi
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// вызовы методов хранилища
+	DBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "call_duration_seconds",
+		Help:      "Database call latency by method and result.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		DBDuration,
+	)
+}
+
+// Регистрация метрик отдельного компонента, например пула соединений.
+// Метрики с теми же именами уже зарегистрированы другим экземпляром
+// приложения - ошибка, иначе /metrics отдавал бы чужие значения
+func Register(c prometheus.Collector) error {
+	return Registry.Register(c)
+}
+
+// Снятие метрик компонента при его закрытии
+func Unregister(c prometheus.Collector) bool {
+	return Registry.Unregister(c)
+}
+
+// Обработчик выдачи метрик, сжатие выполняет общая прослойка роутера
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		Registry:           Registry,
+		DisableCompression: true,
+	})
+}
@@ -5,98 +5,93 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
-	"github.com/eugene982/yp-gophermart/internal/logger"
 	"github.com/go-chi/jwtauth/v5"
-)
-
-const (
-	secretKey = "SecretSignKey"
-	tokenExp  = time.Hour * 3
-)
 
-var (
-	tokenAuth *jwtauth.JWTAuth
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
 )
 
 type contextKeyType uint
 
 const (
 	contextKeyUserID contextKeyType = iota
+	contextKeyRequestID
+	contextKeySessionID
 )
 
-func init() {
-	// аутентификация
-	tokenAuth = jwtauth.New("HS256", []byte(secretKey), nil)
+// Проверка токена доступа
+type TokenVerifier interface {
+	VerifyAccessToken(ctx context.Context, accessToken string) (model.TokenClaims, error)
 }
 
-// Прослойка аутинтификации пользователя с помощью куки
-func CookieAuth(next http.Handler) http.Handler {
-
-	fn := func(w http.ResponseWriter, r *http.Request) {
-
-		_, claims, err := jwtauth.FromContext(r.Context())
-		// Токен не создат, или истекло время
-		if errors.Is(err, jwtauth.ErrNoTokenFound) || errors.Is(err, jwtauth.ErrExpired) {
-			logger.Info("unauthorized", "error", err)
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		// 	любая другая ошибка получения токена
-		if err != nil {
-			logger.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+// Прослойка аутинтификации пользователя по токену доступа из заголовка
+// Authorization: Bearer или, если заголовка нет, из куки
+func TokenAuth(verifier TokenVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+
+			tokenString := jwtauth.TokenFromHeader(r)
+			if tokenString == "" {
+				tokenString = jwtauth.TokenFromCookie(r)
+			}
+			if tokenString == "" {
+				logger.FromContext(r.Context()).Info("unauthorized", "error", jwtauth.ErrNoTokenFound)
+				http.Error(w, jwtauth.ErrNoTokenFound.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := verifier.VerifyAccessToken(r.Context(), tokenString)
+			// истекло время, неизвестный ключ, подпись не сошлась или сессия завершена
+			if errors.Is(err, sessions.ErrInvalidToken) {
+				logger.FromContext(r.Context()).Info("unauthorized", "error", err)
+				http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// 	любая другая ошибка проверки токена
+			if err != nil {
+				logger.FromContext(r.Context()).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			// положим идентификатор пользователя и сессии в контекст, что быстро получать
+			ru := RequestWithUserID(r, claims.UserID)
+			ru = ru.WithContext(context.WithValue(ru.Context(), contextKeySessionID, claims.SessionID))
+			logger.FromContext(ru.Context()).Info("authorized")
+
+			next.ServeHTTP(w, ru)
 		}
 
-		// токен существует, проверка идентификатора пользователя
-		id, ok := claims["user_id"]
-		if !ok {
-			logger.Info("user id not found in claims")
-			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		userID, ok := id.(string)
-		if !ok {
-			logger.Error(fmt.Errorf("cannot convert to string"), "user_id", id)
-			http.Error(w, "500 Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		// положим идентификатор пользователя в контекст, что быстро получать
-		//ru := r.WithContext(context.WithValue(ctx, contextKeyUserID, userID))
-		ru := RequestWithUserID(r, userID)
-		logger.Info("cookie", "user_id", userID)
-
-		next.ServeHTTP(w, ru)
+		return http.HandlerFunc(fn)
 	}
-
-	// запускаем через верификатор
-	return jwtauth.Verifier(tokenAuth)(
-		http.HandlerFunc(fn))
 }
 
+// Запрос с идентификатором пользователя, он же добавляется в логгер запроса
 func RequestWithUserID(r *http.Request, userID string) *http.Request {
-	return r.WithContext(context.WithValue(r.Context(), contextKeyUserID, userID))
+	ctx := context.WithValue(r.Context(), contextKeyUserID, userID)
+	return r.WithContext(logger.ContextWith(ctx, "user_id", userID))
 }
 
-// Добавление идентификатора пользователя в куки
-func SetCookieUserID(userID string, w http.ResponseWriter) error {
-	_, tokenString, err := tokenAuth.Encode(map[string]interface{}{
-		"user_id": userID,
-	})
+// Возвращает токен обновления из куки
+func GetRefreshToken(r *http.Request) string {
+	cookie, err := r.Cookie(RefreshCookieName)
 	if err != nil {
-		return err
+		return ""
+	}
+	return cookie.Value
+}
+
+// Возвращает идентификатор сессии из контекста
+func GetSessionID(r *http.Request) (string, error) {
+	sessionID, ok := r.Context().Value(contextKeySessionID).(string)
+	if !ok {
+		return "", fmt.Errorf("session id not found")
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "jwt",
-		Value:   tokenString,
-		Expires: time.Now().Add(tokenExp),
-	})
-	return nil
+	return sessionID, nil
 }
 
 // Возвращает идентификатор пользователя из контекста
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/sessions"
+)
+
+// проверка токена по таблице
+type fakeVerifier map[string]error
+
+func (f fakeVerifier) VerifyAccessToken(ctx context.Context, token string) (model.TokenClaims, error) {
+	if err := f[token]; err != nil {
+		return model.TokenClaims{}, err
+	}
+	return model.TokenClaims{UserID: "user", SessionID: "session"}, nil
+}
+
+func TestTokenAuth(t *testing.T) {
+	verifier := fakeVerifier{
+		"revoked": sessions.ErrInvalidToken,
+		"broken":  errors.New("storage error"),
+	}
+
+	handler := TokenAuth(verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := GetCookieUserID(r)
+		require.NoError(t, err)
+		sessionID, err := GetSessionID(r)
+		require.NoError(t, err)
+		w.Write([]byte(userID + "/" + sessionID))
+	}))
+
+	tests := []struct {
+		name       string
+		cookie     string
+		bearer     string
+		wantStatus int
+	}{
+		{"cookie", "valid", "", http.StatusOK},
+		{"bearer", "", "valid", http.StatusOK},
+		{"bearer first", "revoked", "valid", http.StatusOK},
+		{"no token", "", "", http.StatusUnauthorized},
+		{"invalid cookie", "revoked", "", http.StatusUnauthorized},
+		{"invalid bearer", "valid", "revoked", http.StatusUnauthorized},
+		{"verifier error", "", "broken", http.StatusInternalServerError},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tcase.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: AccessCookieName, Value: tcase.cookie})
+			}
+			if tcase.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tcase.bearer)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tcase.wantStatus, w.Code)
+			if tcase.wantStatus == http.StatusOK {
+				assert.Equal(t, "user/session", w.Body.String())
+			}
+		})
+	}
+}
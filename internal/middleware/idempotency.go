@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+	// тело запроса хешируется и хранится целиком, поэтому ограничено, как у вебхука
+	maxIdempotentBodySize = 1 << 20
+)
+
+// заголовки ответа, которые сохраняются вместе с телом и отдаются при повторе
+var idempotencyReplayHeaders = []string{"Content-Type", "Location"}
+
+// Хранилище ключей идемпотентности
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, info model.IdempotencyInfo) (model.IdempotencyInfo, error)
+	SaveIdempotencyResult(ctx context.Context, info model.IdempotencyInfo) error
+	DeleteIdempotencyKey(ctx context.Context, userID string, key string) error
+}
+
+// структура захвата ответа для сохранения результата запроса
+type idempotencyResponseWriter struct {
+	http.ResponseWriter
+	body       bytes.Buffer
+	statusCode int
+	header     http.Header
+}
+
+// Write implements http.ResponseWriter
+func (i *idempotencyResponseWriter) Write(b []byte) (int, error) {
+	i.capture(http.StatusOK)
+	i.body.Write(b)
+	return i.ResponseWriter.Write(b)
+}
+
+// WriteHeader implements http.ResponseWriter
+func (i *idempotencyResponseWriter) WriteHeader(statusCode int) {
+	i.capture(statusCode)
+	i.ResponseWriter.WriteHeader(statusCode)
+}
+
+// запоминаем код и заголовки в момент их отправки
+func (i *idempotencyResponseWriter) capture(statusCode int) {
+	if i.statusCode != 0 {
+		return
+	}
+	i.statusCode = statusCode
+	i.header = make(http.Header)
+	for _, name := range idempotencyReplayHeaders {
+		if values := i.ResponseWriter.Header().Values(name); len(values) > 0 {
+			i.header[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// Прослойка повторного использования результата запроса по заголовку Idempotency-Key.
+// Повтор с тем же ключом и телом возвращает сохранённый ответ, не выполняя запрос снова.
+// Ответы с ошибкой сервера не сохраняются, такой запрос можно повторить.
+// Должна стоять после аутентификации, ключи уникальны в пределах пользователя
+func Idempotency(store IdempotencyStore, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				logger.FromContext(r.Context()).Info("invalid idempotency key", "length", len(key))
+				http.Error(w, "idempotency key too long", http.StatusBadRequest)
+				return
+			}
+
+			userID, err := GetCookieUserID(r)
+			if err != nil {
+				logger.FromContext(r.Context()).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			if err != nil {
+				logger.FromContext(r.Context()).Info("invalid body", "err", err)
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.Sum256(body)
+			now := time.Now()
+			info := model.IdempotencyInfo{
+				UserID:      userID,
+				Key:         key,
+				RequestHash: hex.EncodeToString(hash[:]),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(ttl),
+			}
+
+			exists, err := store.ClaimIdempotencyKey(r.Context(), info)
+			if errors.Is(err, database.ErrWriteConflict) {
+				replayIdempotent(w, r, info, exists)
+				return
+			} else if err != nil {
+				logger.FromContext(r.Context()).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			// при панике обработчика ключ освобождается, иначе до истечения
+			// срока повторы получали бы 409
+			defer func() {
+				if p := recover(); p != nil {
+					if err := store.DeleteIdempotencyKey(context.Background(), userID, key); err != nil {
+						logger.FromContext(r.Context()).Error(err, "idempotency_key", key)
+					}
+					panic(p)
+				}
+			}()
+
+			iw := &idempotencyResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(iw, r)
+
+			// запрос можно будет повторить
+			if iw.statusCode == 0 || iw.statusCode >= http.StatusInternalServerError {
+				if err = store.DeleteIdempotencyKey(context.Background(), userID, key); err != nil {
+					logger.FromContext(r.Context()).Error(err, "idempotency_key", key)
+				}
+				return
+			}
+
+			info.StatusCode = iw.statusCode
+			info.Response = iw.body.Bytes()
+			if info.Header, err = json.Marshal(iw.header); err != nil {
+				logger.FromContext(r.Context()).Error(err, "idempotency_key", key)
+			}
+			if err = store.SaveIdempotencyResult(context.Background(), info); err != nil {
+				logger.FromContext(r.Context()).Error(err, "idempotency_key", key)
+			}
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Ответ на повторный запрос с уже использованным ключом
+func replayIdempotent(w http.ResponseWriter, r *http.Request, request, exists model.IdempotencyInfo) {
+	switch {
+	case exists.RequestHash != request.RequestHash:
+		logger.FromContext(r.Context()).Info("idempotency key reused", "idempotency_key", request.Key)
+		http.Error(w, "idempotency key reused with different request", http.StatusUnprocessableEntity)
+	case exists.StatusCode == 0:
+		logger.FromContext(r.Context()).Info("idempotent request in progress", "idempotency_key", request.Key)
+		http.Error(w, "request is being processed", http.StatusConflict)
+	default:
+		logger.FromContext(r.Context()).Info("idempotent replay", "idempotency_key", request.Key, "status_code", exists.StatusCode)
+		if len(exists.Header) > 0 {
+			var header http.Header
+			if err := json.Unmarshal(exists.Header, &header); err != nil {
+				logger.FromContext(r.Context()).Error(err, "idempotency_key", request.Key)
+			}
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set(IdempotencyReplayedHeader, "true")
+		w.WriteHeader(exists.StatusCode)
+		w.Write(exists.Response)
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/services/database/memory"
+)
+
+func TestIdempotency(t *testing.T) {
+
+	type request struct {
+		key  string
+		body string
+	}
+	tests := []struct {
+		name       string
+		requests   []request
+		handler    int // код ответа обработчика
+		wantStatus []int
+		wantCalls  int
+	}{
+		{
+			name:       "without key",
+			requests:   []request{{"", `{"sum":1}`}, {"", `{"sum":1}`}},
+			handler:    http.StatusOK,
+			wantStatus: []int{200, 200},
+			wantCalls:  2,
+		},
+		{
+			name:       "replay",
+			requests:   []request{{"key", `{"sum":1}`}, {"key", `{"sum":1}`}},
+			handler:    http.StatusPaymentRequired,
+			wantStatus: []int{402, 402},
+			wantCalls:  1,
+		},
+		{
+			name:       "other body",
+			requests:   []request{{"key", `{"sum":1}`}, {"key", `{"sum":2}`}},
+			handler:    http.StatusOK,
+			wantStatus: []int{200, 422},
+			wantCalls:  1,
+		},
+		{
+			name:       "server error is not stored",
+			requests:   []request{{"key", `{"sum":1}`}, {"key", `{"sum":1}`}},
+			handler:    http.StatusInternalServerError,
+			wantStatus: []int{500, 500},
+			wantCalls:  2,
+		},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+
+			var calls int
+			handler := Idempotency(memory.New(), time.Minute)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls++
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("X-Request-Id", "not stored")
+					w.WriteHeader(tcase.handler)
+					w.Write([]byte("body"))
+				}))
+
+			for i, req := range tcase.requests {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("POST", "/", strings.NewReader(req.body))
+				if req.key != "" {
+					r.Header.Set(IdempotencyKeyHeader, req.key)
+				}
+				r = RequestWithUserID(r, "user")
+
+				handler.ServeHTTP(w, r)
+				assert.Equal(t, tcase.wantStatus[i], w.Code)
+
+				if i > 0 && tcase.wantCalls == 1 && w.Code == tcase.handler {
+					assert.Equal(t, "true", w.Header().Get(IdempotencyReplayedHeader))
+					assert.Equal(t, "body", w.Body.String())
+					assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+					assert.Empty(t, w.Header().Get("X-Request-Id"))
+				}
+			}
+			assert.Equal(t, tcase.wantCalls, calls)
+		})
+	}
+}
+
+func TestIdempotencyExpired(t *testing.T) {
+	var calls int
+	handler := Idempotency(memory.New(), -time.Second)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		r.Header.Set(IdempotencyKeyHeader, "key")
+		handler.ServeHTTP(w, RequestWithUserID(r, "user"))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyPanic(t *testing.T) {
+	var calls int
+	handler := Idempotency(memory.New(), time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				panic("handler failed")
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		r.Header.Set(IdempotencyKeyHeader, "key")
+		handler.ServeHTTP(w, RequestWithUserID(r, "user"))
+		return w
+	}
+
+	// паника передаётся дальше, а ключ освобождается
+	require.PanicsWithValue(t, "handler failed", func() { serve() })
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	var calls int
+	handler := Idempotency(memory.New(), time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	w := httptest.NewRecorder()
+	body := strings.Repeat("1", maxIdempotentBodySize+1)
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, "key")
+	handler.ServeHTTP(w, RequestWithUserID(r, "user"))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Zero(t, calls)
+}
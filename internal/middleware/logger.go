@@ -39,7 +39,9 @@ func Logger(next http.Handler) http.Handler {
 		// обернём записывальщик
 		logWriter := &logResponseWriter{w, 0, 0}
 
-		logger.Info(
+		// логгер запроса, строки запроса и ответа связаны его идентификатором
+		log := logger.FromContext(r.Context())
+		log.Info(
 			"incoming request",
 			"method", r.Method,
 			"path", r.URL.Path,
@@ -50,7 +52,7 @@ func Logger(next http.Handler) http.Handler {
 
 		next.ServeHTTP(logWriter, r)
 
-		logger.Info(
+		log.Info(
 			"outgoing response",
 			"status_code", logWriter.statusCode,
 			"size", logWriter.size,
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/eugene982/yp-gophermart/internal/metrics"
+)
+
+// маршрут запроса, не найденного в роутере
+const unmatchedRoute = "unmatched"
+
+// Сбор метрик запросов по шаблону маршрута chi, чтобы номера заказов
+// и другие параметры пути не порождали отдельные ряды
+func Metrics(next http.Handler) http.Handler {
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		writer := &logResponseWriter{w, 0, 0}
+
+		next.ServeHTTP(writer, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := writer.statusCode
+		if status == 0 {
+			status = http.StatusOK // заголовок записан неявно
+		}
+
+		labels := []string{route, r.Method, strconv.Itoa(status)}
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eugene982/yp-gophermart/internal/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Metrics)
+	r.Get("/api/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	r.Post("/api/orders", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	requests := []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/api/orders/1"},
+		{http.MethodGet, "/api/orders/2"},
+		{http.MethodPost, "/api/orders"},
+		{http.MethodGet, "/missing"},
+	}
+	for _, req := range requests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	tests := []struct {
+		labels []string
+		want   float64
+	}{
+		{[]string{"/api/orders/{number}", http.MethodGet, "200"}, 2},
+		{[]string{"/api/orders", http.MethodPost, "202"}, 1},
+		{[]string{unmatchedRoute, http.MethodGet, "404"}, 1},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(tt.labels...)), tt.labels)
+	}
+}
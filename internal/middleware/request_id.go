@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+)
+
+const (
+	// идентификатор запроса во входящих и исходящих заголовках
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+// шаблон маршрута для логгера запроса, вычисляется в момент записи,
+// потому что роутер находит маршрут после прослоек верхнего уровня
+type routePattern struct {
+	rctx *chi.Context
+}
+
+func (p routePattern) String() string {
+	if p.rctx == nil {
+		return ""
+	}
+	return p.rctx.RoutePattern()
+}
+
+// Идентификатор запроса: берётся из заголовка X-Request-ID или создаётся,
+// возвращается в ответе и добавляется в логгер запроса вместе с маршрутом
+func RequestID(next http.Handler) http.Handler {
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+		fields := []any{"request_id", id, "route", routePattern{chi.RouteContext(ctx)}}
+		if span := trace.SpanFromContext(ctx); span.SpanContext().HasTraceID() {
+			span.SetAttributes(attribute.String("request.id", id))
+			fields = append(fields, "trace_id", span.SpanContext().TraceID().String())
+		}
+
+		next.ServeHTTP(w, r.WithContext(logger.ContextWith(ctx, fields...)))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// Возвращает идентификатор запроса из контекста
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID).(string)
+	return id
+}
+
+// Входящий идентификатор принимается, если он не длинный и состоит
+// из печатных символов, чтобы его можно было безопасно писать в лог
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// новый случайный идентификатор
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
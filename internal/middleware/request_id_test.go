@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		inbound  string
+		wantSame bool
+	}{
+		{name: "inbound", inbound: "req-42", wantSame: true},
+		{name: "missing"},
+		{name: "too long", inbound: strings.Repeat("x", maxRequestIDLength+1)},
+		{name: "control chars", inbound: "id\nforged=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = GetRequestID(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.inbound != "" {
+				r.Header.Set(RequestIDHeader, tt.inbound)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.NotEmpty(t, got)
+			assert.Equal(t, got, w.Header().Get(RequestIDHeader))
+			if tt.wantSame {
+				assert.Equal(t, tt.inbound, got)
+			} else {
+				assert.NotEqual(t, tt.inbound, got)
+				assert.Len(t, got, 32)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+const (
+	AccessCookieName  = "jwt"           // кука токена доступа
+	RefreshCookieName = "refresh_token" // кука токена обновления
+
+	// токен обновления нужен только методам сессии
+	refreshCookiePath = "/api/user/"
+)
+
+// Атрибуты кук сессии, зависят от окружения: без HTTPS кука с Secure не сохранится
+type CookieOptions struct {
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+}
+
+// Разбор атрибута SameSite: lax, strict или none
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown cookie SameSite %q", s)
+}
+
+// Выдача токенов клиенту: в куках для браузеров
+// и в теле ответа для клиентов с заголовком Authorization: Bearer
+type TokenWriter struct {
+	opts CookieOptions
+}
+
+// Создание выдачи токенов
+func NewTokenWriter(opts CookieOptions) (*TokenWriter, error) {
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	// браузеры отбрасывают такие куки
+	if opts.SameSite == http.SameSiteNoneMode && !opts.Secure {
+		return nil, fmt.Errorf("cookie SameSite=None requires Secure")
+	}
+	return &TokenWriter{opts: opts}, nil
+}
+
+// Запись токенов в куки и в тело ответа
+func (tw *TokenWriter) WriteTokens(w http.ResponseWriter, tokens model.Tokens) error {
+	tw.SetTokenCookies(w, tokens)
+
+	body, err := json.Marshal(model.TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(tokens.AccessExpiresAt).Round(time.Second).Seconds()),
+		RefreshToken: tokens.RefreshToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	// токены нельзя кешировать
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+// Запись токенов только в куки
+func (tw *TokenWriter) SetTokenCookies(w http.ResponseWriter, tokens model.Tokens) {
+	http.SetCookie(w, tw.cookie(AccessCookieName, "/", tokens.AccessToken, tokens.AccessExpiresAt))
+	http.SetCookie(w, tw.cookie(RefreshCookieName, refreshCookiePath, tokens.RefreshToken, tokens.RefreshExpiresAt))
+}
+
+// Удаление кук сессии
+func (tw *TokenWriter) ClearTokenCookies(w http.ResponseWriter) {
+	for _, c := range []*http.Cookie{
+		tw.cookie(AccessCookieName, "/", "", time.Time{}),
+		tw.cookie(RefreshCookieName, refreshCookiePath, "", time.Time{}),
+	} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+// кука сессии, скриптам страницы она недоступна
+func (tw *TokenWriter) cookie(name, path, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   tw.opts.Domain,
+		Expires:  expires,
+		Secure:   tw.opts.Secure,
+		HttpOnly: true,
+		SameSite: tw.opts.SameSite,
+	}
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+func TestParseSameSite(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    http.SameSite
+		wantErr bool
+	}{
+		{"", http.SameSiteLaxMode, false},
+		{"Lax", http.SameSiteLaxMode, false},
+		{"strict", http.SameSiteStrictMode, false},
+		{"none", http.SameSiteNoneMode, false},
+		{"always", 0, true},
+	}
+	for _, tcase := range tests {
+		got, err := ParseSameSite(tcase.value)
+		if tcase.wantErr {
+			assert.Error(t, err, tcase.value)
+			continue
+		}
+		require.NoError(t, err, tcase.value)
+		assert.Equal(t, tcase.want, got, tcase.value)
+	}
+
+	// без Secure браузер такую куку отбросит
+	_, err := NewTokenWriter(CookieOptions{SameSite: http.SameSiteNoneMode})
+	assert.Error(t, err)
+	_, err = NewTokenWriter(CookieOptions{SameSite: http.SameSiteNoneMode, Secure: true})
+	assert.NoError(t, err)
+}
+
+func TestTokenWriter(t *testing.T) {
+	tokenWriter, err := NewTokenWriter(CookieOptions{
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Domain:   "example.com",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, tokenWriter.WriteTokens(w, model.Tokens{
+		AccessToken:      "access",
+		AccessExpiresAt:  time.Now().Add(time.Minute),
+		RefreshToken:     "refresh",
+		RefreshExpiresAt: time.Now().Add(time.Hour),
+	}))
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+
+	var body model.TokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, model.TokenResponse{
+		AccessToken:  "access",
+		TokenType:    "Bearer",
+		ExpiresIn:    60,
+		RefreshToken: "refresh",
+	}, body)
+
+	cookies := resp.Cookies()
+	require.Len(t, cookies, 2)
+	assert.Equal(t, AccessCookieName, cookies[0].Name)
+	assert.Equal(t, "/", cookies[0].Path)
+	assert.Equal(t, RefreshCookieName, cookies[1].Name)
+	assert.Equal(t, "/api/user/", cookies[1].Path)
+	for _, c := range cookies {
+		assert.True(t, c.Secure, c.Name)
+		assert.True(t, c.HttpOnly, c.Name)
+		assert.Equal(t, http.SameSiteStrictMode, c.SameSite, c.Name)
+		assert.Equal(t, "example.com", c.Domain, c.Name)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	assert.Equal(t, "refresh", GetRefreshToken(r))
+
+	w = httptest.NewRecorder()
+	tokenWriter.ClearTokenCookies(w)
+	cookies = w.Result().Cookies()
+	require.Len(t, cookies, 2)
+	for _, c := range cookies {
+		assert.Empty(t, c.Value)
+		assert.Negative(t, c.MaxAge)
+		assert.True(t, c.Secure, c.Name)
+	}
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Статус заказа
+type OrderStatus string
+
+const (
+	StatusNew        OrderStatus = "NEW"        // заказ загружен, но не обработан
+	StatusProcessing OrderStatus = "PROCESSING" // вознаграждение рассчитывается
+	StatusProcessed  OrderStatus = "PROCESSED"  // расчёт окончен, баллы начислены
+	StatusInvalid    OrderStatus = "INVALID"    // в начислении отказано
+	StatusStuck      OrderStatus = "STUCK"      // система начислений так и не обработала заказ
+)
+
+var ErrUnknownOrderStatus = errors.New("unknown order status")
+
+// допустимые переходы: NEW -> PROCESSING -> PROCESSED/INVALID,
+// система начислений может сразу вернуть окончательный статус.
+// Необработанный заказ переводится в STUCK и может быть возвращён в очередь
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	StatusNew:        {StatusProcessing, StatusProcessed, StatusInvalid, StatusStuck},
+	StatusProcessing: {StatusProcessed, StatusInvalid, StatusStuck},
+	StatusStuck:      {StatusNew, StatusProcessing, StatusProcessed, StatusInvalid},
+}
+
+// Разбор статуса, полученного извне. REGISTERED системы начислений
+// для пользователя означает NEW, внутренний STUCK извне не принимается
+func ParseOrderStatus(s string) (OrderStatus, error) {
+	switch status := OrderStatus(strings.ToUpper(strings.TrimSpace(s))); status {
+	case StatusNew, StatusProcessing, StatusProcessed, StatusInvalid:
+		return status, nil
+	case "REGISTERED":
+		return StatusNew, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownOrderStatus, s)
+}
+
+// Окончательный статус заказа, после него заказ не меняется
+func (s OrderStatus) IsFinal() bool {
+	return s == StatusProcessed || s == StatusInvalid
+}
+
+// Статус, который видит пользователь: зависший заказ для него
+// всё ещё обрабатывается
+func (s OrderStatus) Public() OrderStatus {
+	if s == StatusStuck {
+		return StatusProcessing
+	}
+	return s
+}
+
+// Допустим ли переход в указанный статус
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	for _, next := range orderTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// структура записи истории изменения статуса заказа
+type OrderStatusChange struct {
+	OrderID   int64       `db:"order_id"`
+	From      OrderStatus `db:"from_status"` // пустой при загрузке заказа
+	To        OrderStatus `db:"to_status"`
+	ChangedAt time.Time   `db:"changed_at"`
+}
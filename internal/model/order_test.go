@@ -0,0 +1,62 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOrderStatus(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    OrderStatus
+		wantErr error
+	}{
+		{in: "NEW", want: StatusNew},
+		{in: "REGISTERED", want: StatusNew},
+		{in: "processing", want: StatusProcessing},
+		{in: "PROCESSED", want: StatusProcessed},
+		{in: "INVALID", want: StatusInvalid},
+		{in: "", wantErr: ErrUnknownOrderStatus},
+		{in: "PROCESSSED", wantErr: ErrUnknownOrderStatus},
+		{in: "STUCK", wantErr: ErrUnknownOrderStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseOrderStatus(tt.in)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		want     bool
+	}{
+		{StatusNew, StatusProcessing, true},
+		{StatusNew, StatusProcessed, true},
+		{StatusNew, StatusInvalid, true},
+		{StatusProcessing, StatusProcessed, true},
+		{StatusProcessing, StatusInvalid, true},
+		{StatusNew, StatusNew, false},
+		{StatusProcessing, StatusNew, false},
+		{StatusProcessed, StatusProcessing, false},
+		{StatusProcessed, StatusInvalid, false},
+		{StatusInvalid, StatusProcessed, false},
+		{StatusNew, StatusStuck, true},
+		{StatusProcessing, StatusStuck, true},
+		{StatusStuck, StatusNew, true},
+		{StatusProcessed, StatusStuck, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.from.CanTransitionTo(tt.to))
+		})
+	}
+}
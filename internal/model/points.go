@@ -0,0 +1,140 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Количество знаков после запятой и множитель минимальных единиц
+const (
+	pointsDecimals = 2
+	pointsScale    = 100
+	maxExponent    = 30
+)
+
+// Десятичная запись числа. big.Rat понимает и синтаксис Go: 0x10, 0b11, 1_000, 1/3,
+// такие записи баллами не считаются
+var decimalPattern = regexp.MustCompile(`^[+-]?\d+(\.\d+)?([eE][+-]?\d+)?$`)
+
+var (
+	ErrPointsOverflow = errors.New("points overflow")
+	ErrPointsSyntax   = errors.New("invalid points value")
+)
+
+// Баллы лояльности, хранятся точно в сотых долях (копейках).
+// В JSON представляются десятичным числом, например 505.05,
+// при разборе принимается как число, так и строка "505.05".
+// Значения с большим числом знаков после запятой округляются
+// до сотых по правилу "половина от нуля": 0.295 -> 0.30, -0.295 -> -0.30
+type Points int64
+
+// Разбор десятичной записи баллов без потери точности
+func ParsePoints(s string) (Points, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrPointsSyntax
+	}
+
+	if !decimalPattern.MatchString(s) || !validExponent(s) {
+		return 0, fmt.Errorf("%w: %q", ErrPointsSyntax, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrPointsSyntax, s)
+	}
+	r.Mul(r, big.NewRat(pointsScale, 1))
+
+	// округление половины от нуля
+	num := new(big.Int).Abs(r.Num())
+	quo, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		quo.Neg(quo)
+	}
+
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrPointsOverflow, s)
+	}
+	return Points(quo.Int64()), nil
+}
+
+// Ограничение показателя степени, чтобы запись вида 1e999999999
+// не приводила к вычислениям с огромными числами
+func validExponent(s string) bool {
+	i := strings.IndexAny(s, "eE")
+	if i < 0 {
+		return true
+	}
+	exp, err := strconv.Atoi(s[i+1:])
+	return err == nil && exp >= -maxExponent && exp <= maxExponent
+}
+
+// Сложение с проверкой переполнения
+func (p Points) Add(o Points) (Points, error) {
+	if (o > 0 && p > math.MaxInt64-o) || (o < 0 && p < math.MinInt64-o) {
+		return 0, ErrPointsOverflow
+	}
+	return p + o, nil
+}
+
+// Вычитание с проверкой переполнения
+func (p Points) Sub(o Points) (Points, error) {
+	if (o < 0 && p > math.MaxInt64+o) || (o > 0 && p < math.MinInt64+o) {
+		return 0, ErrPointsOverflow
+	}
+	return p - o, nil
+}
+
+// Десятичная запись без лишних нулей: 505.05, 100, 0.5
+func (p Points) String() string {
+	var sign string
+	u := uint64(p)
+	if p < 0 {
+		sign = "-"
+		u = uint64(-(p + 1)) + 1 // без переполнения для MinInt64
+	}
+
+	s := sign + strconv.FormatUint(u/pointsScale, 10)
+	if frac := u % pointsScale; frac != 0 {
+		f := fmt.Sprintf("%0*d", pointsDecimals, frac)
+		s += "." + strings.TrimRight(f, "0")
+	}
+	return s
+}
+
+// MarshalJSON implements json.Marshaler
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *Points) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	s := string(data)
+	if len(data) > 1 && data[0] == '"' && data[len(data)-1] == '"' {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return fmt.Errorf("%w: %s", ErrPointsSyntax, data)
+		}
+	}
+
+	v, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
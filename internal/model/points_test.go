@@ -0,0 +1,104 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Points
+		wantErr error
+	}{
+		{in: "0", want: 0},
+		{in: "0.29", want: 29},
+		{in: "505.05", want: 50505},
+		{in: "100", want: 10000},
+		{in: "-1.5", want: -150},
+		{in: "1e2", want: 10000},
+		{in: "0.294", want: 29},
+		{in: "0.295", want: 30},
+		{in: "-0.295", want: -30},
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "92233720368547758.08", wantErr: ErrPointsOverflow},
+		{in: "", wantErr: ErrPointsSyntax},
+		{in: "abc", wantErr: ErrPointsSyntax},
+		{in: "1/3", wantErr: ErrPointsSyntax},
+		{in: "1e999999999", wantErr: ErrPointsSyntax},
+		{in: "0x10", wantErr: ErrPointsSyntax},
+		{in: "0b11", wantErr: ErrPointsSyntax},
+		{in: "0o17", wantErr: ErrPointsSyntax},
+		{in: "1_000", wantErr: ErrPointsSyntax},
+		{in: "0x1p4", wantErr: ErrPointsSyntax},
+		{in: "1.", wantErr: ErrPointsSyntax},
+		{in: "+-1", wantErr: ErrPointsSyntax},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.in, func(t *testing.T) {
+			got, err := ParsePoints(tcase.in)
+			if tcase.wantErr != nil {
+				assert.ErrorIs(t, err, tcase.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tcase.want, got)
+		})
+	}
+}
+
+func TestPointsString(t *testing.T) {
+	tests := []struct {
+		in   Points
+		want string
+	}{
+		{in: 0, want: "0"},
+		{in: 29, want: "0.29"},
+		{in: 50, want: "0.5"},
+		{in: 50505, want: "505.05"},
+		{in: 10000, want: "100"},
+		{in: -150, want: "-1.5"},
+		{in: math.MinInt64, want: "-92233720368547758.08"},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.want, func(t *testing.T) {
+			assert.Equal(t, tcase.want, tcase.in.String())
+		})
+	}
+}
+
+func TestPointsJSON(t *testing.T) {
+	var req WithdrawRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"1","sum":0.29}`), &req))
+	assert.Equal(t, Points(29), req.Sum)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"1","sum":"751.1"}`), &req))
+	assert.Equal(t, Points(75110), req.Sum)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"order":"1","sum":true}`), &req))
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"order":"1","sum":"0x10"}`), &req), ErrPointsSyntax)
+
+	body, err := json.Marshal(BalanceResponse{Current: 50505, Withdrawn: 42})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":505.05,"withdrawn":0.42}`, string(body))
+}
+
+func TestPointsArithmetic(t *testing.T) {
+	sum, err := Points(1).Add(2)
+	require.NoError(t, err)
+	assert.Equal(t, Points(3), sum)
+
+	_, err = Points(math.MaxInt64).Add(1)
+	assert.ErrorIs(t, err, ErrPointsOverflow)
+
+	_, err = Points(math.MinInt64).Sub(1)
+	assert.ErrorIs(t, err, ErrPointsOverflow)
+
+	diff, err := Points(1).Sub(3)
+	require.NoError(t, err)
+	assert.Equal(t, Points(-2), diff)
+}
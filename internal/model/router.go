@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"strings"
+	"time"
 )
 
 // структура регистрации пользователя
@@ -22,36 +23,87 @@ func (r LoginReqest) IsValid() (bool, error) {
 	return true, nil
 }
 
+// структура запроса смены пароля
+type PasswordChangeRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// валидация запроса смены пароля
+func (r PasswordChangeRequest) IsValid() (bool, error) {
+	if strings.TrimSpace(r.OldPassword) == "" {
+		return false, errors.New("old password is empty")
+	}
+	if strings.TrimSpace(r.NewPassword) == "" {
+		return false, errors.New("new password is empty")
+	}
+	return true, nil
+}
+
+// токены, выданные при входе или обновлении сессии
+type Tokens struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// структура ответа с токенами сессии
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // секунд до истечения токена доступа
+	RefreshToken string `json:"refresh_token"`
+}
+
+// структура запроса обновления токенов без кук
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// данные пользователя из проверенного токена доступа
+type TokenClaims struct {
+	UserID    string
+	SessionID string
+}
+
 // структура ответа заказа
 type OrderResponse struct {
-	Number     string  `json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float32 `json:"accrual,omitempty"`
-	UploadedAt string  `json:"uploaded_at"`
+	Number     string      `json:"number"`
+	Status     OrderStatus `json:"status"`
+	Accrual    Points      `json:"accrual,omitempty"`
+	UploadedAt string      `json:"uploaded_at"`
 }
 
 // структура ответа баланса баллов
 type BalanceResponse struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   Points `json:"current"`
+	Withdrawn Points `json:"withdrawn"`
 }
 
 // структура запроса на списание средств
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float32 `json:"sum"`
+	Order string `json:"order"`
+	Sum   Points `json:"sum"`
 }
 
 // структура ответа о списании средств
 type WithdrawResponse struct {
-	Order       string  `json:"order"`
-	Sum         float32 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	Order       string `json:"order"`
+	Sum         Points `json:"sum"`
+	ProcessedAt string `json:"processed_at"`
+}
+
+// структура ответа о состоянии сервиса
+type HealthResponse struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	Accrual  string `json:"accrual,omitempty"` // состояние предохранителя системы начислений
 }
 
 // структура ответа внешнего сервиса
 type AccrualResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float32 `json:"accrual,omitempty"`
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual Points `json:"accrual,omitempty"`
 }
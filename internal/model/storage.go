@@ -12,10 +12,14 @@ type UserInfo struct {
 
 // структура записи заказа
 type OrderInfo struct {
-	UserID     string    `db:"user_id"`
-	OrderID    int64     `db:"order_id"`
-	Status     string    `db:"status"`
-	UploadedAt time.Time `db:"uploaded_at"`
+	UserID     string      `db:"user_id"`
+	OrderID    int64       `db:"order_id"`
+	Status     OrderStatus `db:"status"`
+	UploadedAt time.Time   `db:"uploaded_at"`
+	LeaseOwner string      `db:"lease_owner"`  // экземпляр, захвативший заказ для опроса
+	NextPollAt time.Time   `db:"next_poll_at"` // время следующего опроса системы начислений
+	Attempts   int         `db:"attempts"`     // опросов без изменения статуса
+	LastError  string      `db:"last_error"`   // ошибка последнего опроса
 }
 
 // структура записи данных дояльности
@@ -23,13 +27,64 @@ type OperationsInfo struct {
 	UserID     string    `db:"user_id"`
 	OrderID    int64     `db:"order_id"`
 	IsAccrual  bool      `db:"is_accrual"`
-	Points     int       `db:"points"` // *100
+	Points     Points    `db:"points"`
 	UploadedAt time.Time `db:"uploaded_at"`
 }
 
 // структура ответа баланса баллов
 type BalanceInfo struct {
 	UserID    string `db:"user_id"`
-	Current   int    `db:"current"`   // *100
-	Withdrawn int    `db:"withdrawn"` //*100
+	Current   Points `db:"current"`
+	Withdrawn Points `db:"withdrawn"`
+	Version   int64  `db:"version"` // увеличивается при каждом изменении
+}
+
+// расхождение сохранённого баланса с пересчётом по истории операций
+type BalanceDrift struct {
+	UserID            string `db:"user_id"`
+	Current           Points `db:"current"`
+	Withdrawn         Points `db:"withdrawn"`
+	ExpectedCurrent   Points `db:"expected_current"`
+	ExpectedWithdrawn Points `db:"expected_withdrawn"`
+}
+
+// структура записи ключа идемпотентности запроса
+type IdempotencyInfo struct {
+	UserID      string    `db:"user_id"`
+	Key         string    `db:"idem_key"`
+	RequestHash string    `db:"request_hash"`
+	StatusCode  int       `db:"status_code"` // 0 - запрос ещё обрабатывается
+	Response    []byte    `db:"response"`
+	Header      []byte    `db:"response_header"` // заголовки ответа для повтора, JSON
+	CreatedAt   time.Time `db:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+// структура записи сессии пользователя, хранит токен обновления
+type SessionInfo struct {
+	SessionID   string    `db:"session_id"`
+	UserID      string    `db:"user_id"`
+	RefreshHash string    `db:"refresh_hash"` // хеш действующего токена обновления
+	CreatedAt   time.Time `db:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at"` // срок действия токена обновления
+	Revoked     bool      `db:"revoked"`    // сессия завершена, её токены не принимаются
+}
+
+// структура счётчика неудачных попыток входа по логину или адресу клиента
+type LoginAttemptInfo struct {
+	Key           string    `db:"attempt_key"` // "login:<логин>" или "ip:<адрес>"
+	Failures      int       `db:"failures"`    // попыток в текущем окне
+	LastFailureAt time.Time `db:"last_failure_at"`
+	LockedUntil   time.Time `db:"locked_until"` // до этого времени попытки отклоняются
+	Lockout       bool      `db:"lockout"`      // блокировка за превышение порога, а не задержка
+}
+
+// структура записи журнала аудита
+type AuditEvent struct {
+	EventID    int64     `db:"event_id"`
+	Event      string    `db:"event"`
+	Subject    string    `db:"subject"` // к чему относится событие, например ключ счётчика входа
+	RemoteAddr string    `db:"remote_addr"`
+	Details    string    `db:"details"`
+	CreatedAt  time.Time `db:"created_at"`
 }
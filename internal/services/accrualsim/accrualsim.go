@@ -0,0 +1,312 @@
+// Симулятор системы расчёта начислений для локальной разработки и тестов.
+// Реализует GET /api/orders/{number}, регистрацию заказов и правил
+// вознаграждения, а также настраиваемые задержки, отказы 429 и 5xx
+package accrualsim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/utils"
+)
+
+// Статусы расчёта в ответе системы начислений
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+	StatusInvalid    = "INVALID"
+)
+
+// Типы вознаграждения
+const (
+	RewardPercent = "%"  // процент от цены товара
+	RewardPoints  = "pt" // фиксированное количество баллов
+)
+
+var (
+	ErrOrderExists = errors.New("order already registered")
+	ErrRuleExists  = errors.New("reward rule already registered")
+	ErrBadRequest  = errors.New("bad request")
+)
+
+// Настройки поведения симулятора, нулевые значения - без задержек и отказов
+type Options struct {
+	RegisterDelay  time.Duration // после регистрации заказ ещё отвечает 204
+	RegisteredFor  time.Duration // затем отвечает REGISTERED
+	ProcessingFor  time.Duration // затем PROCESSING, после - окончательный статус
+	InvalidRate    float64       // доля заказов, в расчёте которых будет отказано
+	ErrorRate      float64       // доля запросов, на которые отвечаем 500
+	RateLimit      int           // запросов в минуту, 0 - без ограничения
+	AutoRegister   bool          // неизвестные заказы регистрируются при первом запросе
+	DefaultAccrual model.Points  // начисление по заказу без товаров
+	Seed           int64         // начальное значение генератора случайных чисел
+}
+
+// Товар в заказе
+type Good struct {
+	Description string       `json:"description"`
+	Price       model.Points `json:"price"`
+}
+
+// Правило вознаграждения за товары, в описании которых есть Match
+type Rule struct {
+	Match      string       `json:"match"`
+	Reward     model.Points `json:"reward"`
+	RewardType string       `json:"reward_type"`
+}
+
+// Запрос регистрации заказа
+type OrderRequest struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+// зарегистрированный заказ
+type order struct {
+	goods        []Good
+	registeredAt time.Time
+	invalid      bool
+}
+
+type Simulator struct {
+	opts    Options
+	handler http.Handler
+	now     func() time.Time
+
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	orders map[string]*order
+	rules  []Rule
+
+	window   time.Time // начало текущей минуты ограничения запросов
+	requests int       // запросов в текущей минуте
+}
+
+// Создание симулятора
+func New(opts Options) *Simulator {
+	s := &Simulator{
+		opts:   opts,
+		now:    time.Now,
+		rnd:    rand.New(rand.NewSource(opts.Seed)),
+		orders: make(map[string]*order),
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.getOrder)
+	r.Post("/api/orders", s.postOrder)
+	r.Post("/api/goods", s.postGoods)
+	s.handler = r
+	return s
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Регистрация правила вознаграждения
+func (s *Simulator) AddRule(rule Rule) error {
+	if rule.Match == "" || rule.Reward < 0 ||
+		(rule.RewardType != RewardPercent && rule.RewardType != RewardPoints) {
+		return fmt.Errorf("%w: invalid reward rule", ErrBadRequest)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.rules {
+		if r.Match == rule.Match {
+			return ErrRuleExists
+		}
+	}
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+// Регистрация заказа на расчёт
+func (s *Simulator) RegisterOrder(number string, goods ...Good) error {
+	if _, err := utils.OrderNumberToInt(number); err != nil {
+		return fmt.Errorf("%w: %s", ErrBadRequest, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[number]; ok {
+		return ErrOrderExists
+	}
+	s.register(number, goods)
+	return nil
+}
+
+// регистрация заказа, вызывается под блокировкой
+func (s *Simulator) register(number string, goods []Good) {
+	s.orders[number] = &order{
+		goods:        goods,
+		registeredAt: s.now(),
+		invalid:      s.opts.InvalidRate > 0 && s.rnd.Float64() < s.opts.InvalidRate,
+	}
+}
+
+// Ответ по заказу, как его видит клиент в данный момент.
+// false - заказ не зарегистрирован (204)
+func (s *Simulator) Order(number string) (model.AccrualResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order(number)
+}
+
+// ответ по заказу, вызывается под блокировкой
+func (s *Simulator) order(number string) (model.AccrualResponse, bool) {
+	o, ok := s.orders[number]
+	if !ok && s.opts.AutoRegister {
+		if _, err := utils.OrderNumberToInt(number); err == nil {
+			s.register(number, nil)
+			o, ok = s.orders[number], true
+		}
+	}
+	if !ok {
+		return model.AccrualResponse{}, false
+	}
+
+	// статус зависит от времени с момента регистрации
+	elapsed := s.now().Sub(o.registeredAt)
+	resp := model.AccrualResponse{Order: number}
+	switch {
+	case elapsed < s.opts.RegisterDelay:
+		return resp, false
+	case elapsed < s.opts.RegisterDelay+s.opts.RegisteredFor:
+		resp.Status = StatusRegistered
+	case elapsed < s.opts.RegisterDelay+s.opts.RegisteredFor+s.opts.ProcessingFor:
+		resp.Status = StatusProcessing
+	case o.invalid:
+		resp.Status = StatusInvalid
+	default:
+		resp.Status = StatusProcessed
+		resp.Accrual = s.accrual(o.goods)
+	}
+	return resp, true
+}
+
+// расчёт начисления по товарам, для товара применяется первое подходящее правило
+func (s *Simulator) accrual(goods []Good) model.Points {
+	if len(goods) == 0 {
+		return s.opts.DefaultAccrual
+	}
+
+	var sum model.Points
+	for _, g := range goods {
+		for _, r := range s.rules {
+			if !strings.Contains(g.Description, r.Match) {
+				continue
+			}
+			if r.RewardType == RewardPercent {
+				// цена и процент в сотых долях, округление до сотой балла
+				sum += (g.Price*r.Reward + 5000) / 10000
+			} else {
+				sum += r.Reward
+			}
+			break
+		}
+	}
+	return sum
+}
+
+// проверка ограничения запросов, возвращает паузу до следующей минуты
+func (s *Simulator) throttle() (time.Duration, bool) {
+	if s.opts.RateLimit <= 0 {
+		return 0, false
+	}
+
+	now := s.now()
+	if now.Sub(s.window) >= time.Minute {
+		s.window = now
+		s.requests = 0
+	}
+	if s.requests >= s.opts.RateLimit {
+		return s.window.Add(time.Minute).Sub(now), true
+	}
+	s.requests++
+	return 0, false
+}
+
+// информация о расчёте начислений по заказу
+func (s *Simulator) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	s.mu.Lock()
+	wait, throttled := s.throttle()
+	failed := !throttled && s.opts.ErrorRate > 0 && s.rnd.Float64() < s.opts.ErrorRate
+	var (
+		resp model.AccrualResponse
+		ok   bool
+	)
+	if !throttled && !failed {
+		resp, ok = s.order(number)
+	}
+	s.mu.Unlock()
+
+	switch {
+	case throttled:
+		// Retry-After округляется вверх до секунды
+		seconds := int((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "No more than %d requests per minute allowed", s.opts.RateLimit)
+	case failed:
+		http.Error(w, "simulated failure", http.StatusInternalServerError)
+	case !ok:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// регистрация заказа
+func (s *Simulator) postOrder(w http.ResponseWriter, r *http.Request) {
+	var request OrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeError(w, s.RegisterOrder(request.Order, request.Goods...), http.StatusAccepted)
+}
+
+// регистрация правила вознаграждения
+func (s *Simulator) postGoods(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeError(w, s.AddRule(rule), http.StatusOK)
+}
+
+// ответ на запрос регистрации
+func (s *Simulator) writeError(w http.ResponseWriter, err error, okStatus int) {
+	switch {
+	case err == nil:
+		w.WriteHeader(okStatus)
+	case errors.Is(err, ErrBadRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOrderExists), errors.Is(err, ErrRuleExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
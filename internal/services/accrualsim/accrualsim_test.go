@@ -0,0 +1,158 @@
+package accrualsim
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// симулятор с управляемыми часами
+func newTestSimulator(opts Options) (*Simulator, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New(opts)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func get(s *Simulator, number string) *http.Response {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+number, nil))
+	return w.Result()
+}
+
+func post(s *Simulator, path, body string) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return w.Code
+}
+
+func TestOrderProgression(t *testing.T) {
+	s, now := newTestSimulator(Options{
+		RegisterDelay: time.Second,
+		RegisteredFor: time.Second,
+		ProcessingFor: time.Second,
+	})
+
+	require.Equal(t, http.StatusOK, post(s, "/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`))
+	require.Equal(t, http.StatusOK, post(s, "/api/goods", `{"match":"Tefal","reward":7.5,"reward_type":"pt"}`))
+	require.Equal(t, http.StatusAccepted, post(s, "/api/orders",
+		`{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000.55},`+
+			`{"description":"Сковорода Tefal","price":1000},{"description":"Ложка","price":10}]}`))
+
+	tests := []struct {
+		at      time.Duration
+		code    int
+		status  string
+		accrual model.Points
+	}{
+		{at: 0, code: http.StatusNoContent},
+		{at: time.Second, code: http.StatusOK, status: StatusRegistered},
+		{at: 2 * time.Second, code: http.StatusOK, status: StatusProcessing},
+		{at: 3 * time.Second, code: http.StatusOK, status: StatusProcessed, accrual: 70756},
+	}
+	start := *now
+	for _, tt := range tests {
+		*now = start.Add(tt.at)
+		resp := get(s, "12345678903")
+		assert.Equal(t, tt.code, resp.StatusCode, tt.at)
+		if tt.code == http.StatusOK {
+			var body model.AccrualResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, "12345678903", body.Order)
+			assert.Equal(t, tt.status, body.Status)
+			assert.Equal(t, tt.accrual, body.Accrual)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	s, _ := newTestSimulator(Options{})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"order", "/api/orders", `{"order":"79927398713"}`, http.StatusAccepted},
+		{"order exists", "/api/orders", `{"order":"79927398713"}`, http.StatusConflict},
+		{"order luhn", "/api/orders", `{"order":"79927398710"}`, http.StatusBadRequest},
+		{"order json", "/api/orders", `{`, http.StatusBadRequest},
+		{"rule", "/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`, http.StatusOK},
+		{"rule exists", "/api/goods", `{"match":"Bork","reward":5,"reward_type":"pt"}`, http.StatusConflict},
+		{"rule type", "/api/goods", `{"match":"LG","reward":5,"reward_type":"x"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, post(s, tt.path, tt.body))
+		})
+	}
+
+	resp := get(s, "12345678903")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestInvalidAndAutoRegister(t *testing.T) {
+	s, _ := newTestSimulator(Options{
+		InvalidRate:    1,
+		AutoRegister:   true,
+		DefaultAccrual: 500,
+	})
+	resp, ok := s.Order("79927398713")
+	require.True(t, ok)
+	assert.Equal(t, StatusInvalid, resp.Status)
+
+	// с неверным номером заказ не регистрируется
+	_, ok = s.Order("79927398710")
+	assert.False(t, ok)
+
+	s, _ = newTestSimulator(Options{AutoRegister: true, DefaultAccrual: 500})
+	resp, ok = s.Order("79927398713")
+	require.True(t, ok)
+	assert.Equal(t, StatusProcessed, resp.Status)
+	assert.Equal(t, model.Points(500), resp.Accrual)
+}
+
+func TestRateLimit(t *testing.T) {
+	s, now := newTestSimulator(Options{RateLimit: 2, AutoRegister: true})
+
+	for i := 0; i < 2; i++ {
+		resp := get(s, "79927398713")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	*now = now.Add(20 * time.Second)
+	resp := get(s, "79927398713")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "40", resp.Header.Get("Retry-After"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "No more than 2 requests per minute allowed", string(body))
+
+	// в следующей минуте запросы снова выполняются
+	*now = now.Add(40 * time.Second)
+	resp = get(s, "79927398713")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestErrorRate(t *testing.T) {
+	s, _ := newTestSimulator(Options{ErrorRate: 1, AutoRegister: true})
+	resp := get(s, "79927398713")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
@@ -6,34 +6,138 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
-	"strings"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/eugene982/yp-gophermart/internal/logger"
 	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/tracing"
 )
 
 var (
-	updateOrders       = []string{"NEW", "REGISTERED", "PROCESSING"}
+	updateOrders       = []model.OrderStatus{model.StatusNew, model.StatusProcessing}
 	errTooManyRequests = errors.New("too many requests")
+	errNotRegistered   = errors.New("order is not registered in accrual system")
 )
 
+// Настройки опроса внешней системы
+type AccrualOptions struct {
+	Workers      int           // количество одновременных запросов к внешней системе
+	BatchSize    int           // количество заказов, получаемых из БД за один проход
+	OrderTimeout time.Duration // время на обработку одного заказа
+
+	InstanceID    string        // имя экземпляра сервиса, владельца аренды заказов
+	LeaseDuration time.Duration // время аренды заказов, полученных за один проход
+
+	BackoffBase time.Duration // интервал повторного опроса заказа без изменений
+	BackoffMax  time.Duration // предельный интервал повторного опроса
+
+	MaxAttempts int           // опросов без ответа, после которых заказ считается зависшим, 0 - без ограничения
+	MaxAge      time.Duration // время с загрузки, после которого заказ считается зависшим, 0 - без ограничения
+
+	BreakerFailures int           // ошибок подряд до срабатывания предохранителя, 0 - отключён
+	BreakerCooldown time.Duration // пауза после срабатывания предохранителя
+}
+
+// Счётчики работы клиента
+type AccrualStats struct {
+	Processed  uint64 // обработано заказов
+	Updated    uint64 // обновлено заказов
+	Failed     uint64 // ошибок обработки
+	Throttled  uint64 // отказов 429 от внешней системы
+	Stuck      uint64 // заказов переведено в STUCK
+	QueueDepth int    // заказов в очереди к обработчикам
+
+	RateLimit   int       // объявленный внешней системой лимит запросов в минуту
+	PausedUntil time.Time // запросы приостановлены по Retry-After
+
+	Breaker      BreakerState // состояние предохранителя
+	BreakerOpens uint64       // срабатываний предохранителя
+}
+
 type AccrualClient struct {
-	client           *http.Client
-	updateOrderLimit int
-	systemAddress    string
-	stopChan         chan struct{}
+	client        *http.Client
+	opts          AccrualOptions
+	systemAddress string
+	limiter       *rateLimiter
+	breaker       *circuitBreaker
+
+	mu     sync.Mutex         // защищает поля запуска, они меняются при перезапуске
+	cancel context.CancelFunc // отмена опроса
+	done   chan struct{}      // закрывается, когда опрос остановлен
+	jobs   chan accrualJob    // очередь заказов, создаётся при каждом запуске
+
+	queued    atomic.Int64 // заказов в очереди, для статистики без доступа к каналу
+	processed atomic.Uint64
+	updated   atomic.Uint64
+	failed    atomic.Uint64
+	throttled atomic.Uint64
+	stuck     atomic.Uint64
+}
+
+// заказ на обработку и проход, к которому он относится
+type accrualJob struct {
+	order model.OrderInfo
+	batch *accrualBatch
+	poll  trace.SpanContext // спан прохода, родительский для спана заказа
+}
+
+// Один проход по заказам из БД
+type accrualBatch struct {
+	wg      sync.WaitGroup
+	updated atomic.Int64
+	failed  atomic.Int64
 }
 
 // Инициализация клиента
-func NewAccrualClient(timeout time.Duration, address string, limit int) (*AccrualClient, error) {
+func NewAccrualClient(timeout time.Duration, address string, opts AccrualOptions) (*AccrualClient, error) {
 	if address == "" {
 		return nil, fmt.Errorf("acrual system address is emty")
 	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = opts.Workers
+	}
+	if opts.OrderTimeout <= 0 {
+		opts.OrderTimeout = timeout
+	}
+	if opts.InstanceID == "" {
+		host, _ := os.Hostname()
+		opts.InstanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if opts.LeaseDuration <= 0 {
+		// аренды должно хватить, чтобы обработать весь проход
+		opts.LeaseDuration = opts.OrderTimeout * time.Duration(opts.BatchSize/opts.Workers+1)
+	}
+	if opts.BackoffBase <= 0 {
+		opts.BackoffBase = 5 * time.Second
+	}
+	if opts.BackoffMax < opts.BackoffBase {
+		opts.BackoffMax = opts.BackoffBase
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = 30 * time.Second
+	}
+
 	ac := AccrualClient{
-		systemAddress:    address,
-		updateOrderLimit: limit,
+		systemAddress: address,
+		opts:          opts,
+		limiter:       newRateLimiter(),
+		breaker:       newCircuitBreaker(opts.BreakerFailures, opts.BreakerCooldown),
 		client: &http.Client{
 			Timeout: timeout,
 		},
@@ -43,70 +147,314 @@ func NewAccrualClient(timeout time.Duration, address string, limit int) (*Accrua
 }
 
 type OrdersReadWriter interface {
-	ReadOrdersWithStatus(ctx context.Context, status []string, limit int) ([]model.OrderInfo, error)
-	UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual int) error
+	ClaimOrders(ctx context.Context, owner string, status []model.OrderStatus, limit int, lease time.Duration) ([]model.OrderInfo, error)
+	ScheduleOrder(ctx context.Context, order model.OrderInfo) error
+	UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error
 }
 
-// Запуск опроса клиентом внешнего сервиса
-func (ac *AccrualClient) StartReqestAsync(rw OrdersReadWriter, duration time.Duration) {
-	ac.stopChan = make(chan struct{})
-	ticker := time.NewTicker(duration)
+// Запуск опроса клиентом внешнего сервиса, опрос прекращается
+// при отмене ctx или вызове Stop. После Stop опрос можно запустить снова
+func (ac *AccrualClient) StartReqestAsync(ctx context.Context, rw OrdersReadWriter, duration time.Duration) {
+	// канал прошлого запуска закрыт при остановке
+	done, jobs := make(chan struct{}), make(chan accrualJob, ac.opts.BatchSize)
+	ac.mu.Lock()
+	ctx, ac.cancel = context.WithCancel(ctx)
+	ac.done, ac.jobs = done, jobs
+	ac.mu.Unlock()
 
-	go func(tick <-chan time.Time) {
-		for { // пауза между вызовами
+	// обработчики заказов, количество ограничивает нагрузку на внешнюю систему
+	var workers sync.WaitGroup
+	workers.Add(ac.opts.Workers)
+	for i := 0; i < ac.opts.Workers; i++ {
+		go func() {
+			defer workers.Done()
+			ac.worker(ctx, rw, jobs)
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(duration)
+		defer func() {
+			// проход завершён, обработчики дорабатывают и выходят
+			ticker.Stop()
+			close(jobs)
+			workers.Wait()
+			close(done)
+			logger.Info("accrual client stopped")
+		}()
 
+		for { // пауза между вызовами
 			select {
-			case <-ac.stopChan:
-				logger.Info("acrual client stop")
-			case <-tick:
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 				logger.Info("start reqest accrual", "address", ac.systemAddress)
-				count, err := ac.updateOrdersStatuses(rw)
+				start := time.Now()
+				count, err := ac.updateOrdersStatuses(ctx, rw)
 				if err != nil {
 					logger.Warn("end request accrual", "error", err, "update", count)
 				} else {
-					logger.Info("end request accrual", "update", count)
+					logger.Info("end request accrual", "update", count,
+						"duration", time.Since(start).String(),
+						"orders_per_second", float64(count)/time.Since(start).Seconds())
 				}
 			}
 		}
-	}(ticker.C)
+	}()
 }
 
-func (ac *AccrualClient) Stop() {
-	if ac.stopChan != nil {
-		ac.stopChan <- struct{}{}
+// Остановка опроса: текущие запросы отменяются, ожидается завершение
+// обработчиков, но не дольше, чем позволяет ctx. Повторный вызов безопасен
+func (ac *AccrualClient) Stop(ctx context.Context) error {
+	ac.mu.Lock()
+	cancel, done := ac.cancel, ac.done
+	ac.mu.Unlock()
+
+	if cancel == nil {
+		return nil // опрос не запускался
+	}
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("accrual client stop: %w", ctx.Err())
 	}
 }
 
-// Опрос сервиса начисления бонусов
-func (ac *AccrualClient) updateOrdersStatuses(rw OrdersReadWriter) (int, error) {
-	ctx := context.Background()
+// Текущие значения счётчиков
+func (ac *AccrualClient) Stats() AccrualStats {
+	return AccrualStats{
+		Processed:  ac.processed.Load(),
+		Updated:    ac.updated.Load(),
+		Failed:     ac.failed.Load(),
+		Throttled:  ac.throttled.Load(),
+		Stuck:      ac.stuck.Load(),
+		QueueDepth: int(ac.queued.Load()),
+
+		RateLimit:   ac.limiter.Limit(),
+		PausedUntil: ac.limiter.PausedUntil(),
+
+		Breaker:      ac.breaker.State(),
+		BreakerOpens: ac.breaker.Opens(),
+	}
+}
+
+// Состояние предохранителя для проверки работоспособности
+func (ac *AccrualClient) BreakerState() string {
+	return ac.breaker.State().String()
+}
+
+// Опрос сервиса начисления бонусов: захваченные заказы раздаются обработчикам
+// через канал, проход заканчивается, когда обработаны все заказы
+func (ac *AccrualClient) updateOrdersStatuses(ctx context.Context, rw OrdersReadWriter) (count int, err error) {
+	ctx, span := tracing.Start(ctx, "accrual.Poll")
+	defer func() {
+		span.SetAttributes(attribute.Int("accrual.updated", count))
+		tracing.End(span, err)
+	}()
+
+	// пока предохранитель открыт, заказы не захватываются
+	if ac.breaker.State() == BreakerOpen {
+		return 0, errBreakerOpen
+	}
 
-	orders, err := rw.ReadOrdersWithStatus(ctx, updateOrders, ac.updateOrderLimit)
+	orders, err := rw.ClaimOrders(ctx, ac.opts.InstanceID, updateOrders,
+		ac.opts.BatchSize, ac.opts.LeaseDuration)
 	if err != nil {
 		return 0, err
 	}
 
-	var count int
+	ac.mu.Lock()
+	jobs := ac.jobs
+	ac.mu.Unlock()
+
+	batch := new(accrualBatch)
+	batch.wg.Add(len(orders))
 	for _, o := range orders {
-		resp, err := ac.accrualRequestOrder(ctx, o.OrderID)
-		if err != nil {
-			if errors.Is(err, errTooManyRequests) {
-				break
-			}
-			return count, err
-		} else if resp.Order == "" {
-			continue
+		ac.queued.Add(1)
+		jobs <- accrualJob{order: o, batch: batch, poll: span.SpanContext()}
+	}
+	batch.wg.Wait()
+
+	count = int(batch.updated.Load())
+	if failed := batch.failed.Load(); failed > 0 {
+		return count, fmt.Errorf("failed to update %d orders", failed)
+	}
+	return count, nil
+}
+
+// Обработчик заказов из канала
+func (ac *AccrualClient) worker(ctx context.Context, rw OrdersReadWriter, jobs <-chan accrualJob) {
+	for job := range jobs {
+		ac.queued.Add(-1)
+		ac.processJob(ctx, rw, job)
+	}
+}
+
+// Обработка отдельного заказа с ограничением по времени
+func (ac *AccrualClient) processJob(ctx context.Context, rw OrdersReadWriter, job accrualJob) {
+	defer job.batch.wg.Done()
+
+	// отмена берётся из контекста обработчика, от прохода - только трасса
+	ctx, span := tracing.Start(trace.ContextWithSpanContext(ctx, job.poll), "accrual.ProcessOrder",
+		trace.WithAttributes(tracing.OrderIDKey.Int64(job.order.OrderID)))
+	defer span.End()
+
+	// ожидание лимита не входит во время обработки заказа,
+	// при остановке заказ остаётся за нами до истечения аренды
+	if err := ac.limiter.Wait(ctx); err != nil {
+		return
+	}
+
+	orderCtx, cancel := context.WithTimeout(ctx, ac.opts.OrderTimeout)
+	defer cancel()
+
+	ac.processed.Add(1)
+	updated, err := ac.updateOrder(orderCtx, rw, job.order)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// опрос остановлен, попытка не засчитывается
+		logger.Info("order processing cancelled", "order", job.order.OrderID)
+		return
+	case errors.Is(err, database.ErrLeaseLost):
+		// аренда истекла, заказ обрабатывает другой экземпляр
+		logger.Info("order lease lost", "order", job.order.OrderID, "owner", job.order.LeaseOwner)
+		return
+	case errors.Is(err, errTooManyRequests):
+		// заказ будет обработан при следующем проходе
+		ac.throttled.Add(1)
+		logger.Info("accrual system throttled", "order", job.order.OrderID, "error", err)
+	case errors.Is(err, errBreakerOpen):
+		// заказ будет обработан после паузы предохранителя
+	case errors.Is(err, errNotRegistered):
+		// система начислений ещё не знает о заказе, это не ошибка
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+		ac.failed.Add(1)
+		job.batch.failed.Add(1)
+		logger.Warn("update order accrual", "order", job.order.OrderID, "error", err)
+	case updated:
+		ac.updated.Add(1)
+		job.batch.updated.Add(1)
+		return
+	}
+
+	// заказ без изменений опрашивается снова с увеличивающимся интервалом,
+	// при отказе 429 - сразу после паузы, попытка не засчитывается
+	o := job.order
+	if err != nil {
+		o.LastError = err.Error()
+	}
+	if errors.Is(err, errTooManyRequests) {
+		o.NextPollAt = ac.limiter.PausedUntil()
+	} else if errors.Is(err, errBreakerOpen) {
+		o.NextPollAt = ac.breaker.RetryAt()
+	} else {
+		ac.scheduleNext(&o)
+		if ac.isStuck(o) {
+			ac.markStuck(ctx, rw, o)
+			return
 		}
+	}
+	if err = rw.ScheduleOrder(ctx, o); err != nil {
+		logger.Warn("schedule order", "order", o.OrderID, "error", err)
+	}
+}
+
+// Заказ опрашивается слишком долго или слишком много раз
+func (ac *AccrualClient) isStuck(o model.OrderInfo) bool {
+	return (ac.opts.MaxAttempts > 0 && o.Attempts >= ac.opts.MaxAttempts) ||
+		(ac.opts.MaxAge > 0 && time.Since(o.UploadedAt) > ac.opts.MaxAge)
+}
+
+// Перевод заказа в STUCK, дальше он не опрашивается до возврата в очередь вручную
+func (ac *AccrualClient) markStuck(ctx context.Context, rw OrdersReadWriter, o model.OrderInfo) {
+	o.Status = model.StatusStuck
+	if err := rw.UpdateOrderAccrual(ctx, o, 0); err != nil {
+		logger.Warn("mark order stuck", "order", o.OrderID, "error", err)
+		return
+	}
+	ac.reportStuck(o)
+}
+
+// учёт заказа, переведённого в STUCK
+func (ac *AccrualClient) reportStuck(o model.OrderInfo) {
+	ac.stuck.Add(1)
+	logger.Warn("order stuck", "order", o.OrderID, "attempts", o.Attempts, "last_error", o.LastError)
+}
+
+// Назначение следующего опроса заказа, который ещё не обработан
+func (ac *AccrualClient) scheduleNext(o *model.OrderInfo) {
+	o.Attempts++
+	o.NextPollAt = time.Now().Add(ac.backoff(o.Attempts))
+}
+
+// Экспоненциальный интервал повторного опроса со случайным разбросом,
+// чтобы заказы, загруженные одновременно, не опрашивались пачкой
+func (ac *AccrualClient) backoff(attempts int) time.Duration {
+	d := ac.opts.BackoffBase
+	for i := 1; i < attempts && d < ac.opts.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > ac.opts.BackoffMax {
+		d = ac.opts.BackoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Запрос состояния заказа и запись результата
+func (ac *AccrualClient) updateOrder(ctx context.Context, rw OrdersReadWriter, o model.OrderInfo) (bool, error) {
+	resp, err := ac.accrualRequestOrder(ctx, o.OrderID)
+	if err != nil {
+		return false, err
+	} else if resp.Order == "" {
+		return false, errNotRegistered
+	}
 
-		o.Status = strings.ToUpper(resp.Status)
-		accrual := int(resp.Accrual * 100)
-		err = rw.UpdateOrderAccrual(ctx, o, accrual)
-		if err != nil {
-			return count, err
+	// заказ, который система начислений слишком долго держит
+	// необработанным, переводится в STUCK вместо следующего опроса
+	var stuck *model.OrderInfo
+	schedule := func(o *model.OrderInfo) {
+		ac.scheduleNext(o)
+		if ac.isStuck(*o) {
+			o.Status = model.StatusStuck
+			stuck = o
 		}
-		count++
 	}
-	return count, nil
+	if err = applyAccrual(ctx, rw, o, resp, schedule); err != nil {
+		return false, err
+	}
+	if stuck != nil {
+		ac.reportStuck(*stuck)
+	}
+	return true, nil
+}
+
+// Запись результата расчёта начисления
+type accrualWriter interface {
+	UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error
+}
+
+// Применение ответа системы начислений к заказу, общее для опроса и уведомлений.
+// schedule назначает следующий опрос заказа, который ещё не обработан
+func applyAccrual(ctx context.Context, w accrualWriter, o model.OrderInfo,
+	resp model.AccrualResponse, schedule func(*model.OrderInfo)) error {
+
+	status, err := model.ParseOrderStatus(resp.Status)
+	if err != nil {
+		return err
+	}
+	if status != o.Status {
+		o.Attempts = 0 // интервал опроса отсчитывается от смены статуса
+	}
+	o.Status = status
+	o.LastError = ""
+	if !status.IsFinal() && schedule != nil {
+		schedule(&o)
+	}
+	return w.UpdateOrderAccrual(ctx, o, resp.Accrual)
 }
 
 // запрос к внешней системе по отдельному заказу
@@ -117,11 +465,39 @@ func (ac *AccrualClient) accrualRequestOrder(ctx context.Context, orderID int64)
 	default:
 	}
 
-	r, err := ac.client.Get(fmt.Sprintf("%s/api/orders/%d", ac.systemAddress, orderID))
+	ctx, span := tracing.Start(ctx, "accrual.GetOrder", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.OrderIDKey.Int64(orderID)))
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/api/orders/%d", ac.systemAddress, orderID), nil)
 	if err != nil {
 		return
 	}
+	span.SetAttributes(semconv.HTTPMethod(req.Method), semconv.HTTPURL(req.URL.String()))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	if err = ac.breaker.Allow(); err != nil {
+		return
+	}
+	r, err := ac.client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			ac.breaker.Cancel()
+		} else {
+			ac.breaker.Failure()
+		}
+		return
+	}
 	defer r.Body.Close()
+	span.SetAttributes(semconv.HTTPStatusCode(r.StatusCode))
+
+	// ошибки сервера и недоступность считаются отказом внешней системы
+	if r.StatusCode >= http.StatusInternalServerError {
+		ac.breaker.Failure()
+	} else {
+		ac.breaker.Success()
+	}
 
 	switch r.StatusCode {
 	case http.StatusOK:
@@ -132,9 +508,27 @@ func (ac *AccrualClient) accrualRequestOrder(ctx context.Context, orderID int64)
 		return
 
 	case http.StatusTooManyRequests:
-		return res, errTooManyRequests
+		return res, ac.throttle(r)
 	}
 
 	body, _ := io.ReadAll(r.Body)
 	return res, fmt.Errorf("fail request %s %s", r.Status, string(body))
 }
+
+// Обработка ответа 429: все обработчики приостанавливаются до момента
+// из Retry-After, лимит подстраивается под объявленный в теле ответа
+func (ac *AccrualClient) throttle(r *http.Response) error {
+	now := time.Now()
+	retryAfter, ok := parseRetryAfter(r.Header.Get("Retry-After"), now)
+	if !ok {
+		retryAfter = defaultRetryAfter
+	}
+	ac.limiter.PauseUntil(now.Add(retryAfter))
+
+	body, _ := io.ReadAll(r.Body)
+	if limit, ok := parseRequestsPerMinute(string(body)); ok && limit != ac.limiter.Limit() {
+		ac.limiter.SetLimit(limit)
+		logger.Info("accrual rate limit", "requests_per_minute", limit)
+	}
+	return fmt.Errorf("%w: retry after %s", errTooManyRequests, retryAfter)
+}
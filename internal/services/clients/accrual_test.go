@@ -0,0 +1,547 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/accrualsim"
+	"github.com/eugene982/yp-gophermart/internal/services/database/memory"
+	"github.com/eugene982/yp-gophermart/internal/tracing"
+)
+
+// тестовый клиент с запущенными обработчиками без опроса по таймеру
+func newTestClient(t *testing.T, address string, opts AccrualOptions, rw OrdersReadWriter) *AccrualClient {
+	ac, err := NewAccrualClient(time.Second, address, opts)
+	require.NoError(t, err)
+	jobs := make(chan accrualJob, ac.opts.BatchSize)
+	ac.jobs = jobs
+	for i := 0; i < ac.opts.Workers; i++ {
+		go ac.worker(context.Background(), rw, jobs)
+	}
+	t.Cleanup(func() { close(jobs) })
+	return ac
+}
+
+func TestUpdateOrdersStatusesConcurrent(t *testing.T) {
+	const (
+		workers = 4
+		orders  = 40
+	)
+
+	var inFlight, maxInFlight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":"%s","status":"PROCESSED","accrual":1.5}`, number)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	for i := 1; i <= orders; i++ {
+		require.NoError(t, store.WriteNewOrder(ctx, "user", int64(i)))
+	}
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{Workers: workers, BatchSize: orders}, store)
+
+	count, err := ac.updateOrdersStatuses(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, orders, count)
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(workers))
+	assert.Greater(t, maxInFlight.Load(), int32(1))
+
+	balance, err := store.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(orders*150), balance.Current)
+
+	stats := ac.Stats()
+	assert.Equal(t, uint64(orders), stats.Processed)
+	assert.Equal(t, uint64(orders), stats.Updated)
+}
+
+func TestUpdateOrdersStatusesInstances(t *testing.T) {
+	const orders = 40
+
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		mu.Lock()
+		requests[number]++
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+
+		fmt.Fprintf(w, `{"order":"%s","status":"PROCESSED","accrual":1}`, number)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	for i := 1; i <= orders; i++ {
+		require.NoError(t, store.WriteNewOrder(ctx, "user", int64(i)))
+	}
+
+	// два экземпляра сервиса опрашивают одно хранилище
+	var wg sync.WaitGroup
+	for _, id := range []string{"first", "second"} {
+		ac := newTestClient(t, srv.URL, AccrualOptions{Workers: 2, BatchSize: orders / 4, InstanceID: id}, store)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 4; i++ {
+				_, err := ac.updateOrdersStatuses(ctx, store)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, requests, orders)
+	for number, n := range requests {
+		assert.Equal(t, 1, n, "order %s", number)
+	}
+
+	balance, err := store.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(orders*100), balance.Current)
+}
+
+func TestUpdateOrdersStatusesTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 1))
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{
+		Workers:      1,
+		OrderTimeout: 20 * time.Millisecond,
+	}, store)
+
+	start := time.Now()
+	count, err := ac.updateOrdersStatuses(ctx, store)
+	assert.Error(t, err)
+	assert.Equal(t, 0, count)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, uint64(1), ac.Stats().Failed)
+}
+
+func TestUpdateOrdersStatusesRetryAfter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		first := len(requests) == 1
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("No more than 600 requests per minute allowed"))
+			return
+		}
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		fmt.Fprintf(w, `{"order":"%s","status":"PROCESSED","accrual":1}`, number)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, store.WriteNewOrder(ctx, "user", int64(i)))
+	}
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{Workers: 1, BatchSize: 3}, store)
+
+	count, err := ac.updateOrdersStatuses(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, uint64(1), ac.Stats().Throttled)
+	assert.Equal(t, 600, ac.limiter.Limit())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 3)
+	assert.GreaterOrEqual(t, requests[1].Sub(requests[0]), 900*time.Millisecond, "pause from Retry-After")
+	assert.GreaterOrEqual(t, requests[2].Sub(requests[1]), 90*time.Millisecond, "600 requests per minute")
+}
+
+func TestBackoff(t *testing.T) {
+	ac, err := NewAccrualClient(time.Second, "http://localhost", AccrualOptions{
+		BackoffBase: time.Second,
+		BackoffMax:  10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := ac.backoff(tt.attempts)
+			assert.GreaterOrEqual(t, d, tt.max/2, "attempts %d", tt.attempts)
+			assert.LessOrEqual(t, d, tt.max, "attempts %d", tt.attempts)
+		}
+	}
+}
+
+func TestUpdateOrdersStatusesBackoff(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		if number == "1" {
+			w.WriteHeader(http.StatusNoContent) // заказ ещё не зарегистрирован
+			return
+		}
+		fmt.Fprintf(w, `{"order":"%s","status":"PROCESSING"}`, number)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 1))
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 2))
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{
+		Workers:     1,
+		BatchSize:   2,
+		BackoffBase: time.Minute,
+		BackoffMax:  time.Hour,
+	}, store)
+
+	count, err := ac.updateOrdersStatuses(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int32(2), requests.Load())
+
+	orders, err := store.ReadOrders(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	for _, o := range orders {
+		assert.Equal(t, 1, o.Attempts, "order %d", o.OrderID)
+		assert.Greater(t, time.Until(o.NextPollAt), 29*time.Second, "order %d", o.OrderID)
+	}
+	assert.Equal(t, model.StatusProcessing, orders[1].Status)
+
+	// до наступления времени опроса заказы не запрашиваются
+	count, err = ac.updateOrdersStatuses(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestUpdateOrdersStatusesStuck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 1))
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{
+		Workers:     1,
+		BackoffBase: time.Nanosecond,
+		MaxAttempts: 3,
+	}, store)
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Millisecond) // время следующего опроса наступило
+		_, err := ac.updateOrdersStatuses(ctx, store)
+		if i < 3 {
+			assert.Error(t, err)
+		}
+	}
+	assert.Equal(t, uint64(3), ac.Stats().Failed)
+	assert.Equal(t, uint64(1), ac.Stats().Stuck)
+
+	orders, err := store.ReadOrdersWithStatus(ctx, []model.OrderStatus{model.StatusStuck}, 0)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, 3, orders[0].Attempts)
+	assert.Contains(t, orders[0].LastError, "500 Internal Server Error")
+}
+
+func TestUpdateOrdersStatusesStuckProcessing(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   AccrualOptions
+		passes int
+	}{
+		{"max attempts", AccrualOptions{MaxAttempts: 3}, 3},
+		{"max age", AccrualOptions{MaxAge: time.Nanosecond}, 1},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			// система начислений бесконечно держит заказ в обработке
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				fmt.Fprint(w, `{"order":"1","status":"PROCESSING"}`)
+			}))
+			defer srv.Close()
+
+			ctx := context.Background()
+			store := memory.New()
+			require.NoError(t, store.WriteNewOrder(ctx, "user", 1))
+
+			opts := tcase.opts
+			opts.Workers = 1
+			opts.BackoffBase = time.Nanosecond
+			ac := newTestClient(t, srv.URL, opts, store)
+
+			for i := 0; i < tcase.passes+2; i++ {
+				time.Sleep(time.Millisecond) // время следующего опроса наступило
+				_, err := ac.updateOrdersStatuses(ctx, store)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, int32(tcase.passes), requests.Load())
+			assert.Equal(t, uint64(1), ac.Stats().Stuck)
+
+			order, err := store.ReadOrder(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, model.StatusStuck, order.Status)
+		})
+	}
+}
+
+func TestUpdateOrdersStatusesBreaker(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, store.WriteNewOrder(ctx, "user", int64(i)))
+	}
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{
+		Workers:         1,
+		BatchSize:       5,
+		BreakerFailures: 2,
+		BreakerCooldown: time.Hour,
+	}, store)
+
+	// после двух ошибок остальные заказы прохода не запрашиваются
+	_, err := ac.updateOrdersStatuses(ctx, store)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.Equal(t, BreakerOpen, ac.Stats().Breaker)
+	assert.Equal(t, "open", ac.BreakerState())
+
+	// пока предохранитель открыт, проходы пропускаются
+	_, err = ac.updateOrdersStatuses(ctx, store)
+	assert.ErrorIs(t, err, errBreakerOpen)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// непроверенные заказы не потеряли попытку
+	orders, err := store.ReadOrdersWithStatus(ctx, []model.OrderStatus{model.StatusNew}, 0)
+	require.NoError(t, err)
+	require.Len(t, orders, 5)
+	assert.Equal(t, 0, orders[4].Attempts)
+}
+
+func TestStopDrainsWorkers(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// ответ не приходит до отмены запроса
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 1))
+
+	ac, err := NewAccrualClient(time.Minute, srv.URL, AccrualOptions{Workers: 2})
+	require.NoError(t, err)
+
+	// остановка до запуска ничего не делает
+	require.NoError(t, ac.Stop(ctx))
+
+	ac.StartReqestAsync(ctx, store, 10*time.Millisecond)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("request not started")
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, ac.Stop(stopCtx))
+	require.NoError(t, ac.Stop(stopCtx))
+
+	// прерванный запрос не считается ошибкой и попыткой
+	stats := ac.Stats()
+	assert.Zero(t, stats.Failed)
+	assert.Equal(t, BreakerClosed, stats.Breaker)
+
+	orders, err := store.ReadOrdersWithStatus(ctx, []model.OrderStatus{model.StatusNew}, 0)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Zero(t, orders[0].Attempts)
+}
+
+func TestRestartAfterStop(t *testing.T) {
+	ctx := context.Background()
+	ac, err := NewAccrualClient(time.Minute, "http://localhost", AccrualOptions{Workers: 2})
+	require.NoError(t, err)
+
+	// статистика читается сборщиком метрик параллельно с перезапуском
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				ac.Stats()
+			}
+		}
+	}()
+
+	// каждый запуск получает свою очередь, закрытую при остановке
+	for i := 0; i < 3; i++ {
+		ac.StartReqestAsync(ctx, memory.New(), 5*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+
+		stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+		require.NoError(t, ac.Stop(stopCtx), i)
+		cancel()
+	}
+}
+
+func TestUpdateOrdersStatusesSimulator(t *testing.T) {
+	sim := accrualsim.New(accrualsim.Options{
+		ProcessingFor:  30 * time.Millisecond,
+		AutoRegister:   true,
+		DefaultAccrual: 5,
+	})
+	require.NoError(t, sim.AddRule(accrualsim.Rule{Match: "Bork", Reward: 1000, RewardType: accrualsim.RewardPercent}))
+	require.NoError(t, sim.RegisterOrder("12345678903", accrualsim.Good{Description: "Чайник Bork", Price: 10000}))
+	srv := httptest.NewServer(sim)
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 79927398713))
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{
+		Workers:     2,
+		BackoffBase: time.Millisecond,
+	}, store)
+
+	// заказы проходят PROCESSING и получают окончательный статус
+	require.Eventually(t, func() bool {
+		if _, err := ac.updateOrdersStatuses(ctx, store); err != nil {
+			return false
+		}
+		orders, err := store.ReadOrdersWithStatus(ctx, []model.OrderStatus{model.StatusProcessed}, 0)
+		return err == nil && len(orders) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	balance, err := store.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(1005), balance.Current)
+
+	history, err := store.ReadOrderHistory(ctx, 12345678903)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, model.StatusProcessing, history[1].To)
+	assert.Equal(t, model.StatusProcessed, history[2].To)
+}
+
+func TestUpdateOrdersStatusesTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		fmt.Fprint(w, `{"order":"1","status":"PROCESSED","accrual":1}`)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := memory.New()
+	require.NoError(t, store.WriteNewOrder(ctx, "user", 1))
+
+	ac := newTestClient(t, srv.URL, AccrualOptions{Workers: 1}, store)
+	_, err := ac.updateOrdersStatuses(ctx, store)
+	require.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, "accrual.Poll")
+	require.Contains(t, spans, "accrual.ProcessOrder")
+	require.Contains(t, spans, "accrual.GetOrder")
+
+	// заказ обрабатывается в трассе прохода, запрос передаёт контекст трассы
+	poll, process, get := spans["accrual.Poll"], spans["accrual.ProcessOrder"], spans["accrual.GetOrder"]
+	assert.Equal(t, poll.SpanContext().SpanID(), process.Parent().SpanID())
+	assert.Equal(t, process.SpanContext().SpanID(), get.Parent().SpanID())
+	assert.Contains(t, process.Attributes(), tracing.OrderIDKey.Int64(1))
+	assert.Contains(t, traceparent.Load(), get.SpanContext().TraceID().String())
+	assert.Contains(t, traceparent.Load(), get.SpanContext().SpanID().String())
+}
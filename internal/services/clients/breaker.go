@@ -0,0 +1,144 @@
+package clients
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+)
+
+var errBreakerOpen = errors.New("accrual circuit breaker is open")
+
+// Состояние предохранителя
+type BreakerState int32
+
+const (
+	BreakerClosed   BreakerState = iota // запросы выполняются
+	BreakerOpen                         // запросы не выполняются до окончания паузы
+	BreakerHalfOpen                     // выполняется пробный запрос
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Предохранитель запросов к системе начислений. После threshold ошибок подряд
+// запросы не выполняются в течение cooldown, затем один пробный запрос
+// решает, вернуться к обычной работе или подождать ещё
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // 0 - предохранитель отключён
+	cooldown  time.Duration
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // пробный запрос уже выполняется
+	opens    uint64
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Разрешение на запрос, при открытом предохранителе - errBreakerOpen
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return errBreakerOpen
+		}
+		b.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return errBreakerOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Запрос выполнен, внешняя система работает
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+// Внешняя система не ответила или ответила ошибкой сервера
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == BreakerOpen {
+		return // запрос начат до срабатывания, пауза не продлевается
+	}
+	if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.opens++
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// Запрос не выполнялся, например отменён при завершении работы
+func (b *circuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Текущее состояние, открытый предохранитель после паузы считается полуоткрытым
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Момент, когда можно будет выполнить следующий запрос
+func (b *circuitBreaker) RetryAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		return b.openedAt.Add(b.cooldown)
+	}
+	return time.Now()
+}
+
+// Количество срабатываний
+func (b *circuitBreaker) Opens() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opens
+}
+
+// смена состояния, вызывается под блокировкой
+func (b *circuitBreaker) setState(state BreakerState) {
+	logger.Warn("accrual circuit breaker", "from", b.state.String(), "to", state.String(),
+		"failures", b.failures)
+	b.state = state
+}
@@ -0,0 +1,52 @@
+package clients
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// успешный запрос сбрасывает счётчик ошибок
+	b.Success()
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State())
+
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.ErrorIs(t, b.Allow(), errBreakerOpen)
+	assert.Equal(t, uint64(1), b.Opens())
+
+	// после паузы разрешён только один пробный запрос
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.NoError(t, b.Allow())
+	assert.ErrorIs(t, b.Allow(), errBreakerOpen)
+
+	// неудачная проба снова открывает предохранитель
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.Equal(t, uint64(2), b.Opens())
+
+	time.Sleep(25 * time.Millisecond)
+	assert.NoError(t, b.Allow())
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.NoError(t, b.Allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, BreakerClosed, b.State())
+}
@@ -0,0 +1,164 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// пауза, если внешняя система ответила 429 без заголовка Retry-After
+const defaultRetryAfter = time.Minute
+
+// тело ответа 429: "No more than N requests per minute allowed"
+var reRequestsPerMinute = regexp.MustCompile(`(?i)no more than (\d+) requests per minute`)
+
+// Ограничитель запросов "ведро токенов", общий для всех обработчиков.
+// Пока система начислений не сообщила свой лимит, запросы не ограничиваются.
+// Кроме того, все запросы можно приостановить до указанного момента
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // токенов в секунду, 0 - без ограничений
+	burst  float64
+	tokens float64
+	last   time.Time
+	paused time.Time // до этого момента запросы не выполняются
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{}
+}
+
+// Ожидание разрешения на запрос
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Резервирование токена, возвращает время ожидания до запроса
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// во время паузы токен резервируется на момент её окончания
+	at := now
+	if l.paused.After(now) {
+		at = l.paused
+	}
+	if l.rate == 0 {
+		return at.Sub(now)
+	}
+
+	l.advance(at)
+	l.tokens--
+	delay := at.Sub(now)
+	if l.tokens < 0 {
+		delay += time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	return delay
+}
+
+// пополнение ведра за прошедшее время, вызывается под блокировкой
+func (l *rateLimiter) advance(now time.Time) {
+	if l.last.IsZero() {
+		l.last = now
+		return
+	}
+	if now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+}
+
+// Установка лимита запросов в минуту, запросы распределяются равномерно
+func (l *rateLimiter) SetLimit(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if perMinute <= 0 {
+		l.rate = 0
+		return
+	}
+	l.advance(time.Now())
+	l.rate = float64(perMinute) / 60
+	l.burst = 1
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Текущий лимит запросов в минуту, 0 - без ограничений
+func (l *rateLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.rate*60 + 0.5)
+}
+
+// Приостановка всех запросов до указанного момента
+func (l *rateLimiter) PauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.After(l.paused) {
+		l.paused = t
+	}
+}
+
+// Момент, до которого запросы приостановлены
+func (l *rateLimiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.paused
+}
+
+// Разбор заголовка Retry-After: количество секунд или HTTP-дата
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(value); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Разбор лимита запросов в минуту из тела ответа 429
+func parseRequestsPerMinute(body string) (int, bool) {
+	m := reRequestsPerMinute.FindStringSubmatch(body)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
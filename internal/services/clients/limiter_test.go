@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "seconds", value: "60", want: time.Minute, wantOk: true},
+		{name: "http date", value: now.Add(30 * time.Second).Format(http.TimeFormat), want: 30 * time.Second, wantOk: true},
+		{name: "past date", value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, wantOk: true},
+		{name: "empty", value: ""},
+		{name: "negative", value: "-1"},
+		{name: "garbage", value: "soon"},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tcase.value, now)
+			assert.Equal(t, tcase.wantOk, ok)
+			assert.Equal(t, tcase.want, got)
+		})
+	}
+}
+
+func TestParseRequestsPerMinute(t *testing.T) {
+	n, ok := parseRequestsPerMinute("No more than 42 requests per minute allowed")
+	assert.True(t, ok)
+	assert.Equal(t, 42, n)
+
+	_, ok = parseRequestsPerMinute("Too Many Requests")
+	assert.False(t, ok)
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newRateLimiter()
+
+	// без лимита не ждём
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, l.Wait(ctx))
+	}
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// 1200 в минуту - 20 в секунду, запросы через 50 мс
+	l.SetLimit(1200)
+	assert.Equal(t, 1200, l.Limit())
+
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, l.Wait(ctx))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// пауза действует на всех
+	l.PauseUntil(time.Now().Add(100 * time.Millisecond))
+	start = time.Now()
+	assert.NoError(t, l.Wait(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// отмена ожидания
+	l.PauseUntil(time.Now().Add(time.Hour))
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+}
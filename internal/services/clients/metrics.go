@@ -0,0 +1,59 @@
+package clients
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// описания метрик опроса системы начислений
+var (
+	descProcessed = prometheus.NewDesc("gophermart_accrual_orders_processed_total",
+		"Orders taken by accrual poller workers.", nil, nil)
+	descResults = prometheus.NewDesc("gophermart_accrual_order_results_total",
+		"Accrual poller order outcomes.", []string{"result"}, nil)
+	descThrottled = prometheus.NewDesc("gophermart_accrual_throttled_total",
+		"Accrual system 429 responses.", nil, nil)
+	descQueueDepth = prometheus.NewDesc("gophermart_accrual_queue_depth",
+		"Claimed orders waiting for a worker.", nil, nil)
+	descRateLimit = prometheus.NewDesc("gophermart_accrual_rate_limit",
+		"Requests per minute announced by accrual system, 0 - unlimited.", nil, nil)
+	descPaused = prometheus.NewDesc("gophermart_accrual_paused_seconds",
+		"Time left until requests are resumed after Retry-After.", nil, nil)
+	descBreakerState = prometheus.NewDesc("gophermart_accrual_breaker_state",
+		"Circuit breaker state: 0 - closed, 1 - open, 2 - half-open.", nil, nil)
+	descBreakerOpens = prometheus.NewDesc("gophermart_accrual_breaker_opens_total",
+		"Circuit breaker openings.", nil, nil)
+)
+
+// Утверждение типа, ошибка компиляции
+var _ prometheus.Collector = (*AccrualClient)(nil)
+
+// Describe implements prometheus.Collector
+func (ac *AccrualClient) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{descProcessed, descResults, descThrottled, descQueueDepth,
+		descRateLimit, descPaused, descBreakerState, descBreakerOpens} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector, значения берутся из счётчиков клиента
+func (ac *AccrualClient) Collect(ch chan<- prometheus.Metric) {
+	stats := ac.Stats()
+
+	ch <- prometheus.MustNewConstMetric(descProcessed, prometheus.CounterValue, float64(stats.Processed))
+	ch <- prometheus.MustNewConstMetric(descResults, prometheus.CounterValue, float64(stats.Updated), "updated")
+	ch <- prometheus.MustNewConstMetric(descResults, prometheus.CounterValue, float64(stats.Failed), "failed")
+	ch <- prometheus.MustNewConstMetric(descResults, prometheus.CounterValue, float64(stats.Stuck), "stuck")
+	ch <- prometheus.MustNewConstMetric(descThrottled, prometheus.CounterValue, float64(stats.Throttled))
+	ch <- prometheus.MustNewConstMetric(descQueueDepth, prometheus.GaugeValue, float64(stats.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(descRateLimit, prometheus.GaugeValue, float64(stats.RateLimit))
+
+	paused := time.Until(stats.PausedUntil)
+	if paused < 0 {
+		paused = 0
+	}
+	ch <- prometheus.MustNewConstMetric(descPaused, prometheus.GaugeValue, paused.Seconds())
+	ch <- prometheus.MustNewConstMetric(descBreakerState, prometheus.GaugeValue, float64(stats.Breaker))
+	ch <- prometheus.MustNewConstMetric(descBreakerOpens, prometheus.CounterValue, float64(stats.BreakerOpens))
+}
@@ -0,0 +1,41 @@
+package clients
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	ac, err := NewAccrualClient(time.Second, "http://localhost", AccrualOptions{
+		BreakerFailures: 1,
+		BreakerCooldown: time.Hour,
+	})
+	require.NoError(t, err)
+
+	ac.throttled.Add(3)
+	ac.limiter.SetLimit(60)
+	ac.breaker.Failure()
+
+	want := `
+# HELP gophermart_accrual_breaker_opens_total Circuit breaker openings.
+# TYPE gophermart_accrual_breaker_opens_total counter
+gophermart_accrual_breaker_opens_total 1
+# HELP gophermart_accrual_breaker_state Circuit breaker state: 0 - closed, 1 - open, 2 - half-open.
+# TYPE gophermart_accrual_breaker_state gauge
+gophermart_accrual_breaker_state 1
+# HELP gophermart_accrual_rate_limit Requests per minute announced by accrual system, 0 - unlimited.
+# TYPE gophermart_accrual_rate_limit gauge
+gophermart_accrual_rate_limit 60
+# HELP gophermart_accrual_throttled_total Accrual system 429 responses.
+# TYPE gophermart_accrual_throttled_total counter
+gophermart_accrual_throttled_total 3
+`
+	assert.NoError(t, testutil.CollectAndCompare(ac, strings.NewReader(want),
+		"gophermart_accrual_breaker_opens_total", "gophermart_accrual_breaker_state",
+		"gophermart_accrual_rate_limit", "gophermart_accrual_throttled_total"))
+}
@@ -0,0 +1,39 @@
+package clients
+
+import (
+	"context"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// Хранилище заказов для уведомлений системы начислений
+type AccrualStore interface {
+	ReadOrder(ctx context.Context, order int64) (model.OrderInfo, error)
+	UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error
+}
+
+// Приём результатов расчёта, которые система начислений присылает сама.
+// Запись выполняется тем же путём, что и при опросе, поэтому повторное
+// уведомление или ответ на опрос по тому же заказу ничего не начисляют повторно
+type AccrualReceiver struct {
+	store AccrualStore
+}
+
+func NewAccrualReceiver(store AccrualStore) *AccrualReceiver {
+	return &AccrualReceiver{store: store}
+}
+
+// Применение уведомления к заказу. Время следующего опроса не меняется,
+// опрос остаётся запасным путём на случай потерянных уведомлений
+func (ar *AccrualReceiver) ApplyAccrual(ctx context.Context, num int64, resp model.AccrualResponse) error {
+	order, err := ar.store.ReadOrder(ctx, num)
+	if err != nil {
+		return err
+	}
+	if err = applyAccrual(ctx, ar.store, order, resp, nil); err != nil {
+		return err
+	}
+	logger.Info("accrual pushed", "order", num, "status", resp.Status)
+	return nil
+}
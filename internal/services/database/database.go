@@ -6,75 +6,149 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/eugene982/yp-gophermart/internal/model"
-	"github.com/jmoiron/sqlx"
 )
 
 var (
 	ErrWriteConflict = errors.New("data exists")
 	ErrNoContent     = errors.New("no content")
 	ErrDBNotInit     = errors.New("database not initialize")
+
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrLeaseLost         = errors.New("order lease lost")
+	ErrInvalidTransition = errors.New("invalid order status transition")
 )
 
-var database Database
+// схема, используемая для строк подключения без явного указания схемы,
+// например "host=localhost user=postgres"
+const defaultScheme = "postgres"
 
-func Open(dns string) (Database, error) {
+// Конструктор экземпляра хранилища
+type Driver func() Database
 
-	if dns == "" {
-		return nil, fmt.Errorf("database dsn is empty")
-	}
+var drivers = make(map[string]Driver)
 
-	db, err := sqlx.Open("pgx", dns)
-	if err != nil {
-		return nil, err
-	}
-	err = db.Ping()
-	if err != nil {
-		return nil, err
-	}
+// Открытие хранилища, драйвер выбирается по схеме строки подключения
+func Open(dsn string) (Database, error) {
 
-	// Настройка пула соединений
-	db.SetMaxOpenConns(3)
-	db.SetMaxIdleConns(3)
-	db.SetConnMaxLifetime(3 * time.Minute)
+	if dsn == "" {
+		return nil, fmt.Errorf("database dsn is empty")
+	}
 
-	if database == nil {
-		return nil, ErrDBNotInit
+	driver, ok := drivers[schemeOf(dsn)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown scheme %q", ErrDBNotInit, schemeOf(dsn))
 	}
 
-	err = database.Open(db)
-	if err != nil {
+	db := driver()
+	if err := db.Open(dsn); err != nil {
 		return nil, err
 	}
+	return db, nil
+}
 
-	return database, nil
+// регистрируем подключенный драйвер для схемы строки подключения
+func RegDriver(scheme string, driver Driver) {
+	drivers[strings.ToLower(scheme)] = driver
 }
 
-// регистрируем подключенный драйвер
-func RegDriver(db Database) {
-	database = db
+// Схема строки подключения
+func schemeOf(dsn string) string {
+	scheme, _, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return defaultScheme
+	}
+	return strings.ToLower(scheme)
 }
 
 // Интерфейс для хранилища данных
 type Database interface {
-	Open(*sqlx.DB) error
+	Open(dsn string) error
 	Close() error
 	Ping(context.Context) error
 
 	WriteUser(ctx context.Context, data model.UserInfo) error
 	ReadUser(ctx context.Context, userID string) (model.UserInfo, error)
+	UpdatePasswordHash(ctx context.Context, userID string, oldHash, newHash string) error
+
+	WriteSession(ctx context.Context, session model.SessionInfo) error
+	ReadSession(ctx context.Context, sessionID string) (model.SessionInfo, error)
+	RotateSession(ctx context.Context, sessionID string, oldHash, newHash string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+
+	ReadLoginAttempt(ctx context.Context, key string) (model.LoginAttemptInfo, error)
+	AddLoginFailures(ctx context.Context, key string, delta int, window time.Duration) (model.LoginAttemptInfo, error)
+	LockLogin(ctx context.Context, key string, until time.Time, lockout bool) (bool, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+
+	WriteAuditEvent(ctx context.Context, event model.AuditEvent) error
+	ReadAuditEvents(ctx context.Context, limit int) ([]model.AuditEvent, error)
 
 	WriteNewOrder(ctx context.Context, userID string, order int64) error
 	ReadOrders(ctx context.Context, userID string, orders ...int64) ([]model.OrderInfo, error)
 
-	WriteWithdraw(ctx context.Context, userID string, order int64, sum int) error
+	Withdraw(ctx context.Context, userID string, order int64, sum model.Points) error
 	ReadWithdraws(ctx context.Context, userID string) ([]model.OperationsInfo, error)
 
 	ReadAccruals(ctx context.Context, userID string) ([]model.OperationsInfo, error)
 
 	ReadBalance(ctx context.Context, userID string) (model.BalanceInfo, error)
-	ReadOrdersWithStatus(ctx context.Context, status []string, limit int) ([]model.OrderInfo, error)
-	UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual int) error
+	ReconcileBalances(ctx context.Context, fix bool) ([]model.BalanceDrift, error)
+	ClaimOrders(ctx context.Context, owner string, status []model.OrderStatus, limit int, lease time.Duration) ([]model.OrderInfo, error)
+	ScheduleOrder(ctx context.Context, order model.OrderInfo) error
+	UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error
+	ReadOrder(ctx context.Context, order int64) (model.OrderInfo, error)
+	ReadOrderHistory(ctx context.Context, order int64) ([]model.OrderStatusChange, error)
+	ReadOrdersWithStatus(ctx context.Context, status []model.OrderStatus, limit int) ([]model.OrderInfo, error)
+	RequeueOrder(ctx context.Context, order int64) error
+	CountOrdersByStatus(ctx context.Context) (map[model.OrderStatus]int64, error)
+
+	ClaimIdempotencyKey(ctx context.Context, info model.IdempotencyInfo) (model.IdempotencyInfo, error)
+	SaveIdempotencyResult(ctx context.Context, info model.IdempotencyInfo) error
+	DeleteIdempotencyKey(ctx context.Context, userID string, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+// Команды управления миграциями схемы
+const (
+	MigrateUp     = "up"     // применить все новые миграции
+	MigrateDown   = "down"   // откатить последнюю применённую миграцию
+	MigrateStatus = "status" // только показать состояние
+)
+
+// Состояние отдельной миграции схемы
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Хранилище, поддерживающее версионные миграции схемы
+type Migrator interface {
+	Migrate(ctx context.Context, dsn string, command string) ([]MigrationStatus, error)
+}
+
+// Выполнение команды миграции для хранилища по строке подключения,
+// возвращает состояние всех миграций после выполнения команды
+func Migrate(ctx context.Context, dsn string, command string) ([]MigrationStatus, error) {
+
+	if dsn == "" {
+		return nil, fmt.Errorf("database dsn is empty")
+	}
+
+	driver, ok := drivers[schemeOf(dsn)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown scheme %q", ErrDBNotInit, schemeOf(dsn))
+	}
+
+	migrator, ok := driver().(Migrator)
+	if !ok {
+		return nil, fmt.Errorf("driver %q does not support migrations", schemeOf(dsn))
+	}
+	return migrator.Migrate(ctx, dsn, command)
 }
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/eugene982/yp-gophermart/internal/metrics"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/tracing"
+)
+
+// время на подсчёт заказов при сборе метрик
+const ordersCollectTimeout = time.Second
+
+// Хранилище, замеряющее время вызова каждого метода
+// и создающее на каждый вызов спан трассировки
+type instrumentedDB struct {
+	Database
+}
+
+// Обёртка хранилища, которая пишет вызовы методов в метрики и трассы
+func Instrument(db Database) Database {
+	return instrumentedDB{db}
+}
+
+// начало вызова метода, возвращённая функция завершает спан и пишет время вызова
+func (m instrumentedDB) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "db."+method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBOperation(method)), trace.WithAttributes(attrs...))
+
+	return ctx, func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.DBDuration.WithLabelValues(method, result).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}
+}
+
+func (m instrumentedDB) Ping(ctx context.Context) (err error) {
+	ctx, done := m.start(ctx, "Ping")
+	defer func() { done(err) }()
+	return m.Database.Ping(ctx)
+}
+
+func (m instrumentedDB) WriteUser(ctx context.Context, data model.UserInfo) (err error) {
+	ctx, done := m.start(ctx, "WriteUser")
+	defer func() { done(err) }()
+	return m.Database.WriteUser(ctx, data)
+}
+
+func (m instrumentedDB) ReadUser(ctx context.Context, userID string) (res model.UserInfo, err error) {
+	ctx, done := m.start(ctx, "ReadUser")
+	defer func() { done(err) }()
+	return m.Database.ReadUser(ctx, userID)
+}
+
+func (m instrumentedDB) UpdatePasswordHash(ctx context.Context, userID string, oldHash, newHash string) (err error) {
+	ctx, done := m.start(ctx, "UpdatePasswordHash")
+	defer func() { done(err) }()
+	return m.Database.UpdatePasswordHash(ctx, userID, oldHash, newHash)
+}
+
+func (m instrumentedDB) WriteSession(ctx context.Context, session model.SessionInfo) (err error) {
+	ctx, done := m.start(ctx, "WriteSession")
+	defer func() { done(err) }()
+	return m.Database.WriteSession(ctx, session)
+}
+
+func (m instrumentedDB) ReadSession(ctx context.Context, sessionID string) (res model.SessionInfo, err error) {
+	ctx, done := m.start(ctx, "ReadSession")
+	defer func() { done(err) }()
+	return m.Database.ReadSession(ctx, sessionID)
+}
+
+func (m instrumentedDB) RotateSession(ctx context.Context, sessionID string, oldHash, newHash string, expiresAt time.Time) (err error) {
+	ctx, done := m.start(ctx, "RotateSession")
+	defer func() { done(err) }()
+	return m.Database.RotateSession(ctx, sessionID, oldHash, newHash, expiresAt)
+}
+
+func (m instrumentedDB) RevokeSession(ctx context.Context, sessionID string) (err error) {
+	ctx, done := m.start(ctx, "RevokeSession")
+	defer func() { done(err) }()
+	return m.Database.RevokeSession(ctx, sessionID)
+}
+
+func (m instrumentedDB) RevokeUserSessions(ctx context.Context, userID string) (err error) {
+	ctx, done := m.start(ctx, "RevokeUserSessions")
+	defer func() { done(err) }()
+	return m.Database.RevokeUserSessions(ctx, userID)
+}
+
+func (m instrumentedDB) ReadLoginAttempt(ctx context.Context, key string) (res model.LoginAttemptInfo, err error) {
+	ctx, done := m.start(ctx, "ReadLoginAttempt")
+	defer func() { done(err) }()
+	return m.Database.ReadLoginAttempt(ctx, key)
+}
+
+func (m instrumentedDB) AddLoginFailures(ctx context.Context, key string, delta int, window time.Duration) (res model.LoginAttemptInfo, err error) {
+	ctx, done := m.start(ctx, "AddLoginFailures")
+	defer func() { done(err) }()
+	return m.Database.AddLoginFailures(ctx, key, delta, window)
+}
+
+func (m instrumentedDB) LockLogin(ctx context.Context, key string, until time.Time, lockout bool) (res bool, err error) {
+	ctx, done := m.start(ctx, "LockLogin")
+	defer func() { done(err) }()
+	return m.Database.LockLogin(ctx, key, until, lockout)
+}
+
+func (m instrumentedDB) ResetLoginAttempts(ctx context.Context, key string) (err error) {
+	ctx, done := m.start(ctx, "ResetLoginAttempts")
+	defer func() { done(err) }()
+	return m.Database.ResetLoginAttempts(ctx, key)
+}
+
+func (m instrumentedDB) WriteAuditEvent(ctx context.Context, event model.AuditEvent) (err error) {
+	ctx, done := m.start(ctx, "WriteAuditEvent")
+	defer func() { done(err) }()
+	return m.Database.WriteAuditEvent(ctx, event)
+}
+
+func (m instrumentedDB) ReadAuditEvents(ctx context.Context, limit int) (res []model.AuditEvent, err error) {
+	ctx, done := m.start(ctx, "ReadAuditEvents")
+	defer func() { done(err) }()
+	return m.Database.ReadAuditEvents(ctx, limit)
+}
+
+func (m instrumentedDB) WriteNewOrder(ctx context.Context, userID string, order int64) (err error) {
+	ctx, done := m.start(ctx, "WriteNewOrder", tracing.OrderIDKey.Int64(order))
+	defer func() { done(err) }()
+	return m.Database.WriteNewOrder(ctx, userID, order)
+}
+
+func (m instrumentedDB) ReadOrders(ctx context.Context, userID string, orders ...int64) (res []model.OrderInfo, err error) {
+	ctx, done := m.start(ctx, "ReadOrders")
+	defer func() { done(err) }()
+	return m.Database.ReadOrders(ctx, userID, orders...)
+}
+
+func (m instrumentedDB) Withdraw(ctx context.Context, userID string, order int64, sum model.Points) (err error) {
+	ctx, done := m.start(ctx, "Withdraw", tracing.OrderIDKey.Int64(order))
+	defer func() { done(err) }()
+	return m.Database.Withdraw(ctx, userID, order, sum)
+}
+
+func (m instrumentedDB) ReadWithdraws(ctx context.Context, userID string) (res []model.OperationsInfo, err error) {
+	ctx, done := m.start(ctx, "ReadWithdraws")
+	defer func() { done(err) }()
+	return m.Database.ReadWithdraws(ctx, userID)
+}
+
+func (m instrumentedDB) ReadAccruals(ctx context.Context, userID string) (res []model.OperationsInfo, err error) {
+	ctx, done := m.start(ctx, "ReadAccruals")
+	defer func() { done(err) }()
+	return m.Database.ReadAccruals(ctx, userID)
+}
+
+func (m instrumentedDB) ReadBalance(ctx context.Context, userID string) (res model.BalanceInfo, err error) {
+	ctx, done := m.start(ctx, "ReadBalance")
+	defer func() { done(err) }()
+	return m.Database.ReadBalance(ctx, userID)
+}
+
+func (m instrumentedDB) ReconcileBalances(ctx context.Context, fix bool) (res []model.BalanceDrift, err error) {
+	ctx, done := m.start(ctx, "ReconcileBalances")
+	defer func() { done(err) }()
+	return m.Database.ReconcileBalances(ctx, fix)
+}
+
+func (m instrumentedDB) ClaimOrders(ctx context.Context, owner string, status []model.OrderStatus, limit int, lease time.Duration) (res []model.OrderInfo, err error) {
+	ctx, done := m.start(ctx, "ClaimOrders")
+	defer func() { done(err) }()
+	return m.Database.ClaimOrders(ctx, owner, status, limit, lease)
+}
+
+func (m instrumentedDB) ScheduleOrder(ctx context.Context, order model.OrderInfo) (err error) {
+	ctx, done := m.start(ctx, "ScheduleOrder", tracing.OrderIDKey.Int64(order.OrderID))
+	defer func() { done(err) }()
+	return m.Database.ScheduleOrder(ctx, order)
+}
+
+func (m instrumentedDB) UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) (err error) {
+	ctx, done := m.start(ctx, "UpdateOrderAccrual", tracing.OrderIDKey.Int64(order.OrderID))
+	defer func() { done(err) }()
+	return m.Database.UpdateOrderAccrual(ctx, order, accrual)
+}
+
+func (m instrumentedDB) ReadOrder(ctx context.Context, order int64) (res model.OrderInfo, err error) {
+	ctx, done := m.start(ctx, "ReadOrder", tracing.OrderIDKey.Int64(order))
+	defer func() { done(err) }()
+	return m.Database.ReadOrder(ctx, order)
+}
+
+func (m instrumentedDB) ReadOrderHistory(ctx context.Context, order int64) (res []model.OrderStatusChange, err error) {
+	ctx, done := m.start(ctx, "ReadOrderHistory", tracing.OrderIDKey.Int64(order))
+	defer func() { done(err) }()
+	return m.Database.ReadOrderHistory(ctx, order)
+}
+
+func (m instrumentedDB) ReadOrdersWithStatus(ctx context.Context, status []model.OrderStatus, limit int) (res []model.OrderInfo, err error) {
+	ctx, done := m.start(ctx, "ReadOrdersWithStatus")
+	defer func() { done(err) }()
+	return m.Database.ReadOrdersWithStatus(ctx, status, limit)
+}
+
+func (m instrumentedDB) RequeueOrder(ctx context.Context, order int64) (err error) {
+	ctx, done := m.start(ctx, "RequeueOrder", tracing.OrderIDKey.Int64(order))
+	defer func() { done(err) }()
+	return m.Database.RequeueOrder(ctx, order)
+}
+
+func (m instrumentedDB) CountOrdersByStatus(ctx context.Context) (res map[model.OrderStatus]int64, err error) {
+	ctx, done := m.start(ctx, "CountOrdersByStatus")
+	defer func() { done(err) }()
+	return m.Database.CountOrdersByStatus(ctx)
+}
+
+func (m instrumentedDB) ClaimIdempotencyKey(ctx context.Context, info model.IdempotencyInfo) (res model.IdempotencyInfo, err error) {
+	ctx, done := m.start(ctx, "ClaimIdempotencyKey")
+	defer func() { done(err) }()
+	return m.Database.ClaimIdempotencyKey(ctx, info)
+}
+
+func (m instrumentedDB) SaveIdempotencyResult(ctx context.Context, info model.IdempotencyInfo) (err error) {
+	ctx, done := m.start(ctx, "SaveIdempotencyResult")
+	defer func() { done(err) }()
+	return m.Database.SaveIdempotencyResult(ctx, info)
+}
+
+func (m instrumentedDB) DeleteIdempotencyKey(ctx context.Context, userID string, key string) (err error) {
+	ctx, done := m.start(ctx, "DeleteIdempotencyKey")
+	defer func() { done(err) }()
+	return m.Database.DeleteIdempotencyKey(ctx, userID, key)
+}
+
+func (m instrumentedDB) DeleteExpiredIdempotencyKeys(ctx context.Context) (res int64, err error) {
+	ctx, done := m.start(ctx, "DeleteExpiredIdempotencyKeys")
+	defer func() { done(err) }()
+	return m.Database.DeleteExpiredIdempotencyKeys(ctx)
+}
+
+// Метрика количества заказов по статусам, считается при каждом сборе
+type ordersCollector struct {
+	db   Database
+	desc *prometheus.Desc
+}
+
+func NewOrdersCollector(db Database) prometheus.Collector {
+	return &ordersCollector{
+		db: db,
+		desc: prometheus.NewDesc("gophermart_orders", "Orders by status.",
+			[]string{"status"}, nil),
+	}
+}
+
+func (c *ordersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *ordersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), ordersCollectTimeout)
+	defer cancel()
+
+	counts, err := c.db.CountOrdersByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	// статусы без заказов отдаются нулями, чтобы ряды не пропадали
+	for _, status := range []model.OrderStatus{model.StatusNew, model.StatusProcessing,
+		model.StatusProcessed, model.StatusInvalid, model.StatusStuck} {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+			float64(counts[status]), string(status))
+	}
+}
@@ -0,0 +1,49 @@
+package database_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/metrics"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/services/database/memory"
+)
+
+// количество замеров времени вызова метода
+func sampleCount(t *testing.T, method, result string) uint64 {
+	var m dto.Metric
+	require.NoError(t, metrics.DBDuration.WithLabelValues(method, result).(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	db := database.Instrument(store)
+
+	require.NoError(t, db.WriteNewOrder(ctx, "user", 1))
+	require.NoError(t, db.WriteNewOrder(ctx, "user", 2))
+	_, err := db.ReadBalance(ctx, "nobody")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+
+	assert.Equal(t, uint64(2), sampleCount(t, "WriteNewOrder", "ok"))
+	assert.Equal(t, uint64(1), sampleCount(t, "ReadBalance", "error"))
+
+	want := `
+# HELP gophermart_orders Orders by status.
+# TYPE gophermart_orders gauge
+gophermart_orders{status="INVALID"} 0
+gophermart_orders{status="NEW"} 2
+gophermart_orders{status="PROCESSED"} 0
+gophermart_orders{status="PROCESSING"} 0
+gophermart_orders{status="STUCK"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(database.NewOrdersCollector(db), strings.NewReader(want)))
+}
@@ -0,0 +1,913 @@
+// Хранение данных в памяти процесса, для локального запуска и тестов
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+var errClosed = errors.New("memory store closed")
+
+type MemStore struct {
+	mu         sync.RWMutex
+	closed     bool
+	users      map[string]model.UserInfo
+	orders     map[int64]model.OrderInfo
+	operations []model.OperationsInfo
+	balances   map[string]model.BalanceInfo
+	idemKeys   map[idemKey]model.IdempotencyInfo
+	sessions   map[string]model.SessionInfo
+	attempts   map[string]model.LoginAttemptInfo
+	audit      []model.AuditEvent
+	leases     map[int64]orderLease
+	history    []model.OrderStatusChange
+}
+
+// аренда заказа экземпляром сервиса
+type orderLease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// ключ записи идемпотентности, уникален в пределах пользователя
+type idemKey struct {
+	userID string
+	key    string
+}
+
+func init() {
+	database.RegDriver("memory", func() database.Database {
+		return New()
+	})
+}
+
+// Утверждение типа, ошибка компиляции
+var _ database.Database = (*MemStore)(nil)
+
+// Создание пустого хранилища
+func New() *MemStore {
+	return &MemStore{
+		users:      make(map[string]model.UserInfo),
+		orders:     make(map[int64]model.OrderInfo),
+		operations: make([]model.OperationsInfo, 0),
+		balances:   make(map[string]model.BalanceInfo),
+		idemKeys:   make(map[idemKey]model.IdempotencyInfo),
+		sessions:   make(map[string]model.SessionInfo),
+		attempts:   make(map[string]model.LoginAttemptInfo),
+		leases:     make(map[int64]orderLease),
+	}
+}
+
+// Функция открытия хранилища, строка подключения не используется
+func (m *MemStore) Open(dsn string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = false
+	return nil
+}
+
+// Закрытие хранилища
+func (m *MemStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// Пинг к хранилищу
+func (m *MemStore) Ping(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return errClosed
+	}
+	return ctx.Err()
+}
+
+// Запись нового пользователя
+func (m *MemStore) WriteUser(ctx context.Context, data model.UserInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	if _, ok := m.users[data.UserID]; ok {
+		return database.ErrWriteConflict
+	}
+	m.users[data.UserID] = data
+	if _, ok := m.balances[data.UserID]; !ok {
+		m.balances[data.UserID] = model.BalanceInfo{UserID: data.UserID}
+	}
+	return nil
+}
+
+// Чтение данных пользователя
+func (m *MemStore) ReadUser(ctx context.Context, userID string) (model.UserInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return model.UserInfo{}, errClosed
+	}
+
+	user, ok := m.users[userID]
+	if !ok {
+		return model.UserInfo{}, database.ErrNoContent
+	}
+	return user, nil
+}
+
+// Замена хеша пароля, если он не изменился с момента чтения
+func (m *MemStore) UpdatePasswordHash(ctx context.Context, userID string, oldHash, newHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	user, ok := m.users[userID]
+	if !ok || user.PasswordHash != oldHash {
+		return database.ErrNoContent
+	}
+	user.PasswordHash = newHash
+	m.users[userID] = user
+	return nil
+}
+
+// Запись новой сессии, заодно удаляются истёкшие
+func (m *MemStore) WriteSession(ctx context.Context, session model.SessionInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	now := time.Now()
+	for id, s := range m.sessions {
+		if s.ExpiresAt.Before(now) {
+			delete(m.sessions, id)
+		}
+	}
+
+	if _, ok := m.sessions[session.SessionID]; ok {
+		return database.ErrWriteConflict
+	}
+	m.sessions[session.SessionID] = session
+	return nil
+}
+
+// Чтение сессии, в том числе завершённой
+func (m *MemStore) ReadSession(ctx context.Context, sessionID string) (model.SessionInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return model.SessionInfo{}, errClosed
+	}
+
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return session, database.ErrNoContent
+	}
+	return session, nil
+}
+
+// Замена токена обновления действующей сессии. Если токен уже заменён
+// или сессия завершена, возвращается ErrNoContent
+func (m *MemStore) RotateSession(ctx context.Context, sessionID string, oldHash, newHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.Revoked || session.RefreshHash != oldHash || !session.ExpiresAt.After(time.Now()) {
+		return database.ErrNoContent
+	}
+	session.RefreshHash = newHash
+	session.ExpiresAt = expiresAt
+	m.sessions[sessionID] = session
+	return nil
+}
+
+// Завершение сессии
+func (m *MemStore) RevokeSession(ctx context.Context, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	if session, ok := m.sessions[sessionID]; ok {
+		session.Revoked = true
+		m.sessions[sessionID] = session
+	}
+	return nil
+}
+
+// Завершение всех сессий пользователя
+func (m *MemStore) RevokeUserSessions(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			session.Revoked = true
+			m.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+// Чтение счётчика попыток входа
+func (m *MemStore) ReadLoginAttempt(ctx context.Context, key string) (model.LoginAttemptInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return model.LoginAttemptInfo{}, errClosed
+	}
+
+	attempt, ok := m.attempts[key]
+	if !ok {
+		return attempt, database.ErrNoContent
+	}
+	return attempt, nil
+}
+
+// Изменение счётчика неудачных попыток на delta. Если последняя попытка
+// была раньше окна или истекла блокировка за превышение порога, счёт
+// начинается заново. Заодно удаляются устаревшие счётчики
+func (m *MemStore) AddLoginFailures(ctx context.Context, key string, delta int, window time.Duration) (model.LoginAttemptInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return model.LoginAttemptInfo{}, errClosed
+	}
+
+	now := time.Now()
+	for k, a := range m.attempts {
+		if a.LastFailureAt.Before(now.Add(-window)) && a.LockedUntil.Before(now) {
+			delete(m.attempts, k)
+		}
+	}
+
+	attempt, ok := m.attempts[key]
+	if !ok {
+		attempt = model.LoginAttemptInfo{Key: key, LastFailureAt: now, LockedUntil: now}
+	} else if attempt.LastFailureAt.Before(now.Add(-window)) ||
+		(attempt.Lockout && !attempt.LockedUntil.After(now)) {
+		attempt.Failures = 0
+		attempt.Lockout = false
+	}
+	attempt.Failures += delta
+	if attempt.Failures < 0 {
+		attempt.Failures = 0
+	}
+	if delta > 0 {
+		attempt.LastFailureAt = now
+	}
+	m.attempts[key] = attempt
+	return attempt, nil
+}
+
+// Блокировка попыток входа до указанного времени, если сейчас попытки не заблокированы.
+// lockout - блокировка за превышение порога, после неё счёт начинается заново.
+// Возвращает true, если блокировку установил этот вызов
+func (m *MemStore) LockLogin(ctx context.Context, key string, until time.Time, lockout bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, errClosed
+	}
+
+	attempt, ok := m.attempts[key]
+	if !ok || attempt.LockedUntil.After(time.Now()) {
+		return false, nil
+	}
+	attempt.LockedUntil = until
+	attempt.Lockout = lockout
+	m.attempts[key] = attempt
+	return true, nil
+}
+
+// Сброс счётчика попыток входа
+func (m *MemStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// Запись события в журнал аудита
+func (m *MemStore) WriteAuditEvent(ctx context.Context, event model.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	event.EventID = int64(len(m.audit)) + 1
+	m.audit = append(m.audit, event)
+	return nil
+}
+
+// Чтение последних событий журнала аудита, сначала новые
+func (m *MemStore) ReadAuditEvents(ctx context.Context, limit int) ([]model.AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	res := make([]model.AuditEvent, 0)
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if limit > 0 && len(res) == limit {
+			break
+		}
+		res = append(res, m.audit[i])
+	}
+	return res, nil
+}
+
+// Запись заказа, номер заказа уникален для всех пользователей
+func (m *MemStore) WriteNewOrder(ctx context.Context, userID string, num int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	if _, ok := m.orders[num]; ok {
+		return database.ErrWriteConflict
+	}
+	now := time.Now()
+	order := model.OrderInfo{
+		UserID:     userID,
+		OrderID:    num,
+		Status:     model.StatusNew,
+		UploadedAt: now,
+		NextPollAt: now,
+	}
+	m.orders[num] = order
+	m.history = append(m.history, model.OrderStatusChange{
+		OrderID:   num,
+		To:        order.Status,
+		ChangedAt: order.UploadedAt,
+	})
+	return nil
+}
+
+// Чтение заказа по номеру независимо от пользователя
+func (m *MemStore) ReadOrder(ctx context.Context, num int64) (model.OrderInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return model.OrderInfo{}, errClosed
+	}
+
+	order, ok := m.orders[num]
+	if !ok {
+		return model.OrderInfo{}, database.ErrNoContent
+	}
+	return order, nil
+}
+
+// История изменения статусов заказа
+func (m *MemStore) ReadOrderHistory(ctx context.Context, num int64) ([]model.OrderStatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	res := make([]model.OrderStatusChange, 0)
+	for _, c := range m.history {
+		if c.OrderID == num {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+// читаем заказы указанного пользователя по списку номеров, если номера не указаны - читаем всё
+func (m *MemStore) ReadOrders(ctx context.Context, userID string, nums ...int64) ([]model.OrderInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	res := make([]model.OrderInfo, 0)
+	if len(nums) == 0 {
+		for _, o := range m.orders {
+			if o.UserID == userID {
+				res = append(res, o)
+			}
+		}
+	} else {
+		for _, num := range nums {
+			if o, ok := m.orders[num]; ok && o.UserID == userID {
+				res = append(res, o)
+			}
+		}
+	}
+	sortOrders(res)
+	return res, nil
+}
+
+// Захват заказов указанных статусов, время опроса которых наступило,
+// заказы с действующей арендой пропускаются
+func (m *MemStore) ClaimOrders(ctx context.Context, owner string, status []model.OrderStatus, limit int, lease time.Duration) ([]model.OrderInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	now := time.Now()
+	res := make([]model.OrderInfo, 0)
+	for _, o := range m.orders {
+		if l, ok := m.leases[o.OrderID]; ok && l.expiresAt.After(now) {
+			continue
+		}
+		if contains(status, o.Status) && !o.NextPollAt.After(now) {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].NextPollAt.Equal(res[j].NextPollAt) {
+			return res[i].OrderID < res[j].OrderID
+		}
+		return res[i].NextPollAt.Before(res[j].NextPollAt)
+	})
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	for i := range res {
+		res[i].LeaseOwner = owner
+		m.leases[res[i].OrderID] = orderLease{owner: owner, expiresAt: now.Add(lease)}
+	}
+	return res, nil
+}
+
+// Освобождение аренды заказа без изменения его состояния
+// и назначение времени следующего опроса
+func (m *MemStore) ScheduleOrder(ctx context.Context, order model.OrderInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	l, ok := m.leases[order.OrderID]
+	if !ok || l.owner != order.LeaseOwner {
+		return nil
+	}
+	delete(m.leases, order.OrderID)
+
+	if order.NextPollAt.IsZero() {
+		order.NextPollAt = time.Now()
+	}
+	current := m.orders[order.OrderID]
+	current.NextPollAt = order.NextPollAt
+	current.Attempts = order.Attempts
+	current.LastError = order.LastError
+	m.orders[order.OrderID] = current
+	return nil
+}
+
+// читаем заказы всех пользователей указанных статусов
+func (m *MemStore) ReadOrdersWithStatus(ctx context.Context, status []model.OrderStatus, limit int) ([]model.OrderInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	res := make([]model.OrderInfo, 0)
+	for _, o := range m.orders {
+		if contains(status, o.Status) {
+			res = append(res, o)
+		}
+	}
+	sortOrders(res)
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// Количество заказов всех пользователей по статусам
+func (m *MemStore) CountOrdersByStatus(ctx context.Context) (map[model.OrderStatus]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	res := make(map[model.OrderStatus]int64)
+	for _, o := range m.orders {
+		res[o.Status]++
+	}
+	return res, nil
+}
+
+// Возврат зависшего заказа в очередь опроса
+func (m *MemStore) RequeueOrder(ctx context.Context, num int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	order, ok := m.orders[num]
+	if !ok {
+		return database.ErrNoContent
+	}
+	if order.Status != model.StatusStuck {
+		return fmt.Errorf("%w: %s -> %s", database.ErrInvalidTransition, order.Status, model.StatusNew)
+	}
+
+	now := time.Now()
+	order.Status = model.StatusNew
+	order.Attempts = 0
+	order.NextPollAt = now
+	order.LastError = ""
+	m.orders[num] = order
+	delete(m.leases, num)
+
+	m.history = append(m.history, model.OrderStatusChange{
+		OrderID:   num,
+		From:      model.StatusStuck,
+		To:        model.StatusNew,
+		ChangedAt: now,
+	})
+	return nil
+}
+
+// Списание баллов, проверка остатка и запись под одной блокировкой
+func (m *MemStore) Withdraw(ctx context.Context, userID string, num int64, sum model.Points) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	// как и в postgres, сначала проверяется остаток, затем повтор номера заказа
+	balance, ok := m.balances[userID]
+	if !ok || balance.Current < sum {
+		return database.ErrInsufficientFunds
+	}
+
+	return m.writeOperation(model.OperationsInfo{
+		UserID:     userID,
+		OrderID:    num,
+		Points:     sum,
+		IsAccrual:  false,
+		UploadedAt: time.Now(),
+	})
+}
+
+// Запись сведений о лояльности и изменение баланса, вызывается под блокировкой.
+// Начисление и списание по одному заказу записываются только один раз
+func (m *MemStore) writeOperation(op model.OperationsInfo) error {
+	if m.hasOperation(op.OrderID, op.IsAccrual) {
+		return database.ErrWriteConflict
+	}
+
+	balance, err := applyOperation(m.balances[op.UserID], op)
+	if err != nil {
+		return err
+	}
+	balance.UserID = op.UserID
+	balance.Version++
+	m.balances[op.UserID] = balance
+	m.operations = append(m.operations, op)
+	return nil
+}
+
+// баланс после операции, с проверкой переполнения
+func applyOperation(b model.BalanceInfo, op model.OperationsInfo) (model.BalanceInfo, error) {
+	var err error
+	if op.IsAccrual {
+		b.Current, err = b.Current.Add(op.Points)
+		return b, err
+	}
+	if b.Current, err = b.Current.Sub(op.Points); err != nil {
+		return b, err
+	}
+	b.Withdrawn, err = b.Withdrawn.Add(op.Points)
+	return b, err
+}
+
+// есть ли операция указанного вида по заказу, вызывается под блокировкой
+func (m *MemStore) hasOperation(num int64, isAccrual bool) bool {
+	for _, op := range m.operations {
+		if op.OrderID == num && op.IsAccrual == isAccrual {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemStore) ReadWithdraws(ctx context.Context, userID string) ([]model.OperationsInfo, error) {
+	return m.readOperations(userID, false)
+}
+
+func (m *MemStore) ReadAccruals(ctx context.Context, userID string) ([]model.OperationsInfo, error) {
+	return m.readOperations(userID, true)
+}
+
+// читаем данные лояльности
+func (m *MemStore) readOperations(userID string, isAccrual bool) ([]model.OperationsInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	res := make([]model.OperationsInfo, 0)
+	for _, op := range m.operations {
+		if op.UserID == userID && op.IsAccrual == isAccrual {
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
+
+// читаем баланс пользователя
+func (m *MemStore) ReadBalance(ctx context.Context, userID string) (model.BalanceInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return model.BalanceInfo{}, errClosed
+	}
+
+	balance, ok := m.balances[userID]
+	if !ok {
+		return model.BalanceInfo{}, database.ErrNoContent
+	}
+	return balance, nil
+}
+
+// Сверка балансов с историей операций, при fix расхождения исправляются
+func (m *MemStore) ReconcileBalances(ctx context.Context, fix bool) ([]model.BalanceDrift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, errClosed
+	}
+
+	expected := make(map[string]model.BalanceInfo)
+	for _, op := range m.operations {
+		b, err := applyOperation(expected[op.UserID], op)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", op.UserID, err)
+		}
+		expected[op.UserID] = b
+	}
+
+	users := make(map[string]struct{})
+	for userID := range m.balances {
+		users[userID] = struct{}{}
+	}
+	for userID := range expected {
+		users[userID] = struct{}{}
+	}
+
+	res := make([]model.BalanceDrift, 0)
+	for userID := range users {
+		stored, exp := m.balances[userID], expected[userID]
+		if stored.Current == exp.Current && stored.Withdrawn == exp.Withdrawn {
+			continue
+		}
+		res = append(res, model.BalanceDrift{
+			UserID:            userID,
+			Current:           stored.Current,
+			Withdrawn:         stored.Withdrawn,
+			ExpectedCurrent:   exp.Current,
+			ExpectedWithdrawn: exp.Withdrawn,
+		})
+
+		if fix {
+			m.balances[userID] = model.BalanceInfo{
+				UserID:    userID,
+				Current:   exp.Current,
+				Withdrawn: exp.Withdrawn,
+				Version:   stored.Version + 1,
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].UserID < res[j].UserID
+	})
+	return res, nil
+}
+
+// Обновление сведений заказа, добавление записей о начислении скидок.
+// Заказ, захваченный для опроса, обновляется только владельцем аренды.
+// Повтор окончательного статуса ничего не меняет, недопустимый переход
+// статуса отклоняется, остальные записываются в историю
+func (m *MemStore) UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	current, ok := m.orders[order.OrderID]
+	if !ok {
+		return nil // как и UPDATE без подходящих строк
+	}
+	if order.LeaseOwner != "" {
+		if l, ok := m.leases[order.OrderID]; !ok || l.owner != order.LeaseOwner {
+			return database.ErrLeaseLost
+		}
+	}
+	changed := current.Status != order.Status
+	switch {
+	case !changed && current.Status.IsFinal():
+		return nil // повторный ответ об уже обработанном заказе
+	case changed && !current.Status.CanTransitionTo(order.Status):
+		return fmt.Errorf("%w: %s -> %s", database.ErrInvalidTransition, current.Status, order.Status)
+	}
+
+	// начисление проверяется до изменения заказа, чтобы при переполнении
+	// баланса заказ не остался обработанным без начисления
+	if accrual != 0 && !m.hasOperation(order.OrderID, true) {
+		op := model.OperationsInfo{IsAccrual: true, Points: accrual}
+		if _, err := applyOperation(m.balances[current.UserID], op); err != nil {
+			return err
+		}
+	}
+
+	// владелец и время загрузки заказа не меняются
+	updated := current
+	updated.Status = order.Status
+	updated.LeaseOwner = ""
+	updated.NextPollAt = order.NextPollAt
+	if updated.NextPollAt.IsZero() {
+		updated.NextPollAt = time.Now()
+	}
+	updated.Attempts = order.Attempts
+	updated.LastError = order.LastError
+	delete(m.leases, order.OrderID)
+	m.orders[order.OrderID] = updated
+
+	if changed {
+		m.history = append(m.history, model.OrderStatusChange{
+			OrderID:   order.OrderID,
+			From:      current.Status,
+			To:        order.Status,
+			ChangedAt: time.Now(),
+		})
+	}
+
+	if accrual != 0 {
+		// начисление по заказу уже записано
+		_ = m.writeOperation(model.OperationsInfo{
+			UserID:     current.UserID,
+			OrderID:    order.OrderID,
+			IsAccrual:  true,
+			Points:     accrual,
+			UploadedAt: time.Now(),
+		})
+	}
+	return nil
+}
+
+// Захват ключа идемпотентности. Если действующий ключ уже есть,
+// возвращается его запись и ErrWriteConflict
+func (m *MemStore) ClaimIdempotencyKey(ctx context.Context, info model.IdempotencyInfo) (model.IdempotencyInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return model.IdempotencyInfo{}, errClosed
+	}
+
+	// просроченный ключ больше не действует
+	k := idemKey{info.UserID, info.Key}
+	if exists, ok := m.idemKeys[k]; ok && !exists.ExpiresAt.Before(time.Now()) {
+		return exists, database.ErrWriteConflict
+	}
+	m.idemKeys[k] = info
+	return info, nil
+}
+
+// Сохранение результата выполнения запроса
+func (m *MemStore) SaveIdempotencyResult(ctx context.Context, info model.IdempotencyInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	k := idemKey{info.UserID, info.Key}
+	exists, ok := m.idemKeys[k]
+	if !ok {
+		return database.ErrNoContent
+	}
+	exists.StatusCode = info.StatusCode
+	exists.Response = append([]byte(nil), info.Response...)
+	exists.Header = append([]byte(nil), info.Header...)
+	m.idemKeys[k] = exists
+	return nil
+}
+
+// Удаление ключа, чтобы запрос можно было повторить
+func (m *MemStore) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errClosed
+	}
+
+	delete(m.idemKeys, idemKey{userID, key})
+	return nil
+}
+
+// Удаление просроченных ключей идемпотентности, возвращает количество удалённых
+func (m *MemStore) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, errClosed
+	}
+
+	var n int64
+	now := time.Now()
+	for k, v := range m.idemKeys {
+		if v.ExpiresAt.Before(now) {
+			delete(m.idemKeys, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// сортировка заказов по времени загрузки
+func sortOrders(orders []model.OrderInfo) {
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].UploadedAt.Equal(orders[j].UploadedAt) {
+			return orders[i].OrderID < orders[j].OrderID
+		}
+		return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+	})
+}
+
+func contains(list []model.OrderStatus, s model.OrderStatus) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
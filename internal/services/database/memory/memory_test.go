@@ -0,0 +1,533 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+func TestOpenByScheme(t *testing.T) {
+	db, err := database.Open("memory://")
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.IsType(t, &MemStore{}, db)
+	assert.NoError(t, db.Ping(context.Background()))
+}
+
+func TestClosed(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, m.Close())
+
+	// после закрытия хранилище не работает, как и закрытое соединение с БД
+	assert.ErrorIs(t, m.Ping(ctx), errClosed)
+	assert.ErrorIs(t, m.WriteNewOrder(ctx, "user", 79927398713), errClosed)
+	_, err := m.ReadOrder(ctx, 12345678903)
+	assert.ErrorIs(t, err, errClosed)
+	_, err = m.ReadWithdraws(ctx, "user")
+	assert.ErrorIs(t, err, errClosed)
+
+	require.NoError(t, m.Open("memory://"))
+	_, err = m.ReadOrder(ctx, 12345678903)
+	assert.NoError(t, err)
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	_, err := m.ReadUser(ctx, "user")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+
+	user := model.UserInfo{UserID: "user", PasswordHash: "hash"}
+	require.NoError(t, m.WriteUser(ctx, user))
+	assert.ErrorIs(t, m.WriteUser(ctx, user), database.ErrWriteConflict)
+
+	got, err := m.ReadUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+}
+
+func TestUpdatePasswordHash(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	require.NoError(t, m.WriteUser(ctx, model.UserInfo{UserID: "user", PasswordHash: "old"}))
+
+	// хеш уже заменён другим запросом
+	assert.ErrorIs(t, m.UpdatePasswordHash(ctx, "user", "other", "new"), database.ErrNoContent)
+	assert.ErrorIs(t, m.UpdatePasswordHash(ctx, "nobody", "old", "new"), database.ErrNoContent)
+
+	require.NoError(t, m.UpdatePasswordHash(ctx, "user", "old", "new"))
+	got, err := m.ReadUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "new", got.PasswordHash)
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	now := time.Now()
+	session := model.SessionInfo{SessionID: "s1", UserID: "user", RefreshHash: "h1",
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, m.WriteSession(ctx, session))
+	require.NoError(t, m.WriteSession(ctx, model.SessionInfo{SessionID: "s2", UserID: "user",
+		RefreshHash: "h2", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	_, err := m.ReadSession(ctx, "none")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+
+	// заменить можно только действующий токен
+	assert.ErrorIs(t, m.RotateSession(ctx, "s1", "other", "h3", now.Add(time.Hour)), database.ErrNoContent)
+	require.NoError(t, m.RotateSession(ctx, "s1", "h1", "h3", now.Add(2*time.Hour)))
+	assert.ErrorIs(t, m.RotateSession(ctx, "s1", "h1", "h4", now.Add(time.Hour)), database.ErrNoContent)
+
+	got, err := m.ReadSession(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "h3", got.RefreshHash)
+	assert.False(t, got.Revoked)
+
+	require.NoError(t, m.RevokeSession(ctx, "s1"))
+	got, err = m.ReadSession(ctx, "s1")
+	require.NoError(t, err)
+	assert.True(t, got.Revoked)
+	assert.ErrorIs(t, m.RotateSession(ctx, "s1", "h3", "h5", now.Add(time.Hour)), database.ErrNoContent)
+
+	require.NoError(t, m.RevokeUserSessions(ctx, "user"))
+	got, err = m.ReadSession(ctx, "s2")
+	require.NoError(t, err)
+	assert.True(t, got.Revoked)
+}
+
+func TestLoginAttempts(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	_, err := m.ReadLoginAttempt(ctx, "login:user")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+
+	// блокировать можно только существующий счётчик
+	locked, err := m.LockLogin(ctx, "login:user", time.Now().Add(time.Minute), false)
+	require.NoError(t, err)
+	assert.False(t, locked)
+
+	for i := 1; i <= 3; i++ {
+		got, err := m.AddLoginFailures(ctx, "login:user", 1, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, i, got.Failures)
+	}
+	got, err := m.AddLoginFailures(ctx, "login:user", -1, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Failures)
+
+	// повторная блокировка не продлевает действующую
+	until := time.Now().Add(time.Minute)
+	locked, err = m.LockLogin(ctx, "login:user", until, false)
+	require.NoError(t, err)
+	assert.True(t, locked)
+	locked, err = m.LockLogin(ctx, "login:user", until.Add(time.Hour), true)
+	require.NoError(t, err)
+	assert.False(t, locked)
+
+	got, err = m.ReadLoginAttempt(ctx, "login:user")
+	require.NoError(t, err)
+	assert.Equal(t, until, got.LockedUntil)
+
+	// неудача за пределами окна начинает счёт заново
+	time.Sleep(10 * time.Millisecond)
+	got, err = m.AddLoginFailures(ctx, "login:user", 1, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Failures)
+
+	// после истёкшей задержки счёт продолжается
+	require.NoError(t, m.ResetLoginAttempts(ctx, "login:user"))
+	_, err = m.AddLoginFailures(ctx, "login:user", 1, time.Hour)
+	require.NoError(t, err)
+	_, err = m.AddLoginFailures(ctx, "login:user", 1, time.Hour)
+	require.NoError(t, err)
+	locked, err = m.LockLogin(ctx, "login:user", time.Now(), false)
+	require.NoError(t, err)
+	require.True(t, locked)
+	got, err = m.AddLoginFailures(ctx, "login:user", 1, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, got.Failures)
+
+	// после истёкшей блокировки за превышение порога счёт начинается заново
+	locked, err = m.LockLogin(ctx, "login:user", time.Now(), true)
+	require.NoError(t, err)
+	require.True(t, locked)
+	got, err = m.AddLoginFailures(ctx, "login:user", 1, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Failures)
+	assert.False(t, got.Lockout)
+
+	require.NoError(t, m.ResetLoginAttempts(ctx, "login:user"))
+	_, err = m.ReadLoginAttempt(ctx, "login:user")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+}
+
+func TestAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	for _, subject := range []string{"login:a", "login:b", "ip:192.0.2.1"} {
+		require.NoError(t, m.WriteAuditEvent(ctx, model.AuditEvent{
+			Event: "login_lockout", Subject: subject, CreatedAt: time.Now()}))
+	}
+
+	events, err := m.ReadAuditEvents(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "ip:192.0.2.1", events[0].Subject)
+	assert.Equal(t, int64(3), events[0].EventID)
+	assert.Equal(t, "login:b", events[1].Subject)
+}
+
+func TestOrders(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 79927398713))
+	assert.ErrorIs(t, m.WriteNewOrder(ctx, "other", 12345678903), database.ErrWriteConflict)
+
+	orders, err := m.ReadOrders(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, orders, 2)
+
+	orders, err = m.ReadOrders(ctx, "other", 12345678903)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+
+	orders, err = m.ClaimOrders(ctx, "instance", []model.OrderStatus{model.StatusNew}, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	order := orders[0]
+	order.Status = "PROCESSED"
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 50505))
+
+	orders, err = m.ClaimOrders(ctx, "instance", []model.OrderStatus{model.StatusNew}, 0, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, orders, 1)
+
+	accruals, err := m.ReadAccruals(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, accruals, 1)
+	assert.Equal(t, model.Points(50505), accruals[0].Points)
+}
+
+func TestClaimOrders(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, m.WriteNewOrder(ctx, "user", i))
+	}
+
+	first, err := m.ClaimOrders(ctx, "first", []model.OrderStatus{model.StatusNew}, 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	assert.Equal(t, "first", first[0].LeaseOwner)
+
+	// захваченные заказы другому экземпляру не достаются
+	second, err := m.ClaimOrders(ctx, "second", []model.OrderStatus{model.StatusNew}, 0, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, int64(3), second[0].OrderID)
+
+	// освобождённый заказ можно захватить снова
+	require.NoError(t, m.ScheduleOrder(ctx, first[1]))
+	second, err = m.ClaimOrders(ctx, "second", []model.OrderStatus{model.StatusNew}, 0, -time.Second)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, first[1].OrderID, second[0].OrderID)
+
+	// просроченная аренда захвачена другим экземпляром
+	second, err = m.ClaimOrders(ctx, "second", []model.OrderStatus{model.StatusNew}, 0, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+
+	lost := first[1]
+	lost.Status = "PROCESSED"
+	assert.ErrorIs(t, m.UpdateOrderAccrual(ctx, lost, 100), database.ErrLeaseLost)
+
+	// несуществующий заказ не обновляется без ошибки, как в postgres
+	unknown := model.OrderInfo{OrderID: 999, Status: model.StatusProcessed, LeaseOwner: "a"}
+	assert.NoError(t, m.UpdateOrderAccrual(ctx, unknown, 100))
+
+	order := first[0]
+	order.Status = "PROCESSED"
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 100))
+
+	orders, err := m.ReadOrders(ctx, "user", order.OrderID)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, model.StatusProcessed, orders[0].Status)
+	assert.Empty(t, orders[0].LeaseOwner)
+
+	accruals, err := m.ReadAccruals(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, accruals, 1)
+}
+
+func TestAccrualOverflow(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 79927398713))
+	require.NoError(t, m.UpdateOrderAccrual(ctx, model.OrderInfo{UserID: "user",
+		OrderID: 12345678903, Status: "PROCESSED"}, math.MaxInt64))
+
+	// начисление, переполняющее баланс, не меняет ни заказ, ни баланс
+	order := model.OrderInfo{UserID: "user", OrderID: 79927398713, Status: "PROCESSED"}
+	assert.ErrorIs(t, m.UpdateOrderAccrual(ctx, order, 1), model.ErrPointsOverflow)
+
+	got, err := m.ReadOrder(ctx, 79927398713)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusNew, got.Status)
+	balance, err := m.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(math.MaxInt64), balance.Current)
+
+	drifts, err := m.ReconcileBalances(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestUpdateOrderAccrualKeepsOwner(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	stored, err := m.ReadOrder(ctx, 12345678903)
+	require.NoError(t, err)
+
+	// владелец и время загрузки из переданной структуры не записываются
+	order := model.OrderInfo{UserID: "other", OrderID: 12345678903, Status: model.StatusProcessed,
+		UploadedAt: stored.UploadedAt.Add(time.Hour)}
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 100))
+
+	updated, err := m.ReadOrder(ctx, 12345678903)
+	require.NoError(t, err)
+	assert.Equal(t, "user", updated.UserID)
+	assert.Equal(t, stored.UploadedAt, updated.UploadedAt)
+	assert.Equal(t, model.StatusProcessed, updated.Status)
+
+	balance, err := m.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(100), balance.Current)
+	_, err = m.ReadBalance(ctx, "other")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+}
+
+func TestUpdateOrderAccrualOnce(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	order := model.OrderInfo{UserID: "user", OrderID: 12345678903, Status: "PROCESSING"}
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 0))
+
+	order.Status = "PROCESSED"
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 1000))
+	// повторный ответ системы начислений
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 1000))
+
+	order.Status = "PROCESSING"
+	assert.ErrorIs(t, m.UpdateOrderAccrual(ctx, order, 0), database.ErrInvalidTransition)
+
+	accruals, err := m.ReadAccruals(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, accruals, 1)
+
+	balance, err := m.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(1000), balance.Current)
+
+	orders, err := m.ReadOrders(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, model.StatusProcessed, orders[0].Status)
+
+	history, err := m.ReadOrderHistory(ctx, 12345678903)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	for i, want := range [][2]model.OrderStatus{
+		{"", model.StatusNew},
+		{model.StatusNew, model.StatusProcessing},
+		{model.StatusProcessing, model.StatusProcessed},
+	} {
+		assert.Equal(t, want, [2]model.OrderStatus{history[i].From, history[i].To})
+	}
+}
+
+func TestRequeueOrder(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	assert.ErrorIs(t, m.RequeueOrder(ctx, 1), database.ErrNoContent)
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 1))
+	assert.ErrorIs(t, m.RequeueOrder(ctx, 1), database.ErrInvalidTransition)
+
+	order := model.OrderInfo{UserID: "user", OrderID: 1, Status: model.StatusStuck,
+		Attempts: 10, LastError: "not registered", NextPollAt: time.Now().Add(time.Hour)}
+	require.NoError(t, m.UpdateOrderAccrual(ctx, order, 0))
+
+	stuck, err := m.ReadOrdersWithStatus(ctx, []model.OrderStatus{model.StatusStuck}, 0)
+	require.NoError(t, err)
+	require.Len(t, stuck, 1)
+	assert.Equal(t, "not registered", stuck[0].LastError)
+
+	// зависший заказ не опрашивается
+	orders, err := m.ClaimOrders(ctx, "instance", []model.OrderStatus{model.StatusNew}, 0, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+
+	require.NoError(t, m.RequeueOrder(ctx, 1))
+	orders, err = m.ClaimOrders(ctx, "instance", []model.OrderStatus{model.StatusNew}, 0, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, 0, orders[0].Attempts)
+	assert.Empty(t, orders[0].LastError)
+
+	history, err := m.ReadOrderHistory(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, model.StatusStuck, history[2].From)
+	assert.Equal(t, model.StatusNew, history[2].To)
+}
+
+func TestBalance(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	_, err := m.ReadBalance(ctx, "user")
+	assert.ErrorIs(t, err, database.ErrNoContent)
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, m.UpdateOrderAccrual(ctx,
+		model.OrderInfo{UserID: "user", OrderID: 12345678903, Status: "PROCESSED"}, 50505))
+	require.NoError(t, m.Withdraw(ctx, "user", 2377225624, 10000))
+	assert.ErrorIs(t, m.Withdraw(ctx, "user", 2377225624, 1), database.ErrWriteConflict)
+	assert.ErrorIs(t, m.Withdraw(ctx, "user", 79927398713, 40506), database.ErrInsufficientFunds)
+	// как в postgres: нехватка средств проверяется раньше повтора заказа
+	assert.ErrorIs(t, m.Withdraw(ctx, "user", 2377225624, 40506), database.ErrInsufficientFunds)
+
+	balance, err := m.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.BalanceInfo{UserID: "user", Current: 40505, Withdrawn: 10000, Version: 2}, balance)
+
+	withdraws, err := m.ReadWithdraws(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, withdraws, 1)
+	assert.Equal(t, int64(2377225624), withdraws[0].OrderID)
+}
+
+func TestWithdrawConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, m.UpdateOrderAccrual(ctx,
+		model.OrderInfo{UserID: "user", OrderID: 12345678903, Status: "PROCESSED"}, 1000))
+
+	const workers = 100
+	var (
+		wg      sync.WaitGroup
+		success atomic.Int32
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := m.Withdraw(ctx, "user", int64(i), 100)
+			if err == nil {
+				success.Add(1)
+			} else {
+				assert.ErrorIs(t, err, database.ErrInsufficientFunds)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(10), success.Load())
+
+	balance, err := m.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(0), balance.Current)
+	assert.Equal(t, model.Points(1000), balance.Withdrawn)
+}
+
+func TestReconcileBalances(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	require.NoError(t, m.WriteUser(ctx, model.UserInfo{UserID: "user"}))
+	require.NoError(t, m.WriteNewOrder(ctx, "user", 12345678903))
+	require.NoError(t, m.UpdateOrderAccrual(ctx,
+		model.OrderInfo{UserID: "user", OrderID: 12345678903, Status: "PROCESSED"}, 1000))
+
+	drifts, err := m.ReconcileBalances(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	// портим сохранённый баланс
+	m.balances["user"] = model.BalanceInfo{UserID: "user", Current: 1, Version: 1}
+
+	drifts, err = m.ReconcileBalances(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, []model.BalanceDrift{{
+		UserID:          "user",
+		Current:         1,
+		ExpectedCurrent: 1000,
+	}}, drifts)
+
+	balance, err := m.ReadBalance(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, model.Points(1000), balance.Current)
+
+	drifts, err = m.ReconcileBalances(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	now := time.Now()
+
+	expired := model.IdempotencyInfo{UserID: "user", Key: "old", ExpiresAt: now.Add(-time.Second)}
+	active := model.IdempotencyInfo{UserID: "user", Key: "new", ExpiresAt: now.Add(time.Minute)}
+	for _, info := range []model.IdempotencyInfo{expired, active} {
+		_, err := m.ClaimIdempotencyKey(ctx, info)
+		require.NoError(t, err)
+	}
+
+	// захват другого ключа не удаляет просроченные
+	require.Len(t, m.idemKeys, 2)
+
+	// просроченный ключ можно захватить снова
+	_, err := m.ClaimIdempotencyKey(ctx, expired)
+	require.NoError(t, err)
+	_, err = m.ClaimIdempotencyKey(ctx, active)
+	require.ErrorIs(t, err, database.ErrWriteConflict)
+
+	n, err := m.DeleteExpiredIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Len(t, m.idemKeys, 1)
+}
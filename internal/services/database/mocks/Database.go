@@ -9,7 +9,7 @@ import (
 
 	model "github.com/eugene982/yp-gophermart/internal/model"
 
-	sqlx "github.com/jmoiron/sqlx"
+	time "time"
 )
 
 // Database is an autogenerated mock type for the Database type
@@ -17,6 +17,80 @@ type Database struct {
 	mock.Mock
 }
 
+// AddLoginFailures provides a mock function with given fields: ctx, key, delta, window
+func (_m *Database) AddLoginFailures(ctx context.Context, key string, delta int, window time.Duration) (model.LoginAttemptInfo, error) {
+	ret := _m.Called(ctx, key, delta, window)
+
+	var r0 model.LoginAttemptInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, time.Duration) (model.LoginAttemptInfo, error)); ok {
+		return rf(ctx, key, delta, window)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, time.Duration) model.LoginAttemptInfo); ok {
+		r0 = rf(ctx, key, delta, window)
+	} else {
+		r0 = ret.Get(0).(model.LoginAttemptInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, time.Duration) error); ok {
+		r1 = rf(ctx, key, delta, window)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimIdempotencyKey provides a mock function with given fields: ctx, info
+func (_m *Database) ClaimIdempotencyKey(ctx context.Context, info model.IdempotencyInfo) (model.IdempotencyInfo, error) {
+	ret := _m.Called(ctx, info)
+
+	var r0 model.IdempotencyInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdempotencyInfo) (model.IdempotencyInfo, error)); ok {
+		return rf(ctx, info)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdempotencyInfo) model.IdempotencyInfo); ok {
+		r0 = rf(ctx, info)
+	} else {
+		r0 = ret.Get(0).(model.IdempotencyInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.IdempotencyInfo) error); ok {
+		r1 = rf(ctx, info)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimOrders provides a mock function with given fields: ctx, owner, status, limit, lease
+func (_m *Database) ClaimOrders(ctx context.Context, owner string, status []model.OrderStatus, limit int, lease time.Duration) ([]model.OrderInfo, error) {
+	ret := _m.Called(ctx, owner, status, limit, lease)
+
+	var r0 []model.OrderInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []model.OrderStatus, int, time.Duration) ([]model.OrderInfo, error)); ok {
+		return rf(ctx, owner, status, limit, lease)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []model.OrderStatus, int, time.Duration) []model.OrderInfo); ok {
+		r0 = rf(ctx, owner, status, limit, lease)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.OrderInfo)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []model.OrderStatus, int, time.Duration) error); ok {
+		r1 = rf(ctx, owner, status, limit, lease)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *Database) Close() error {
 	ret := _m.Called()
@@ -31,13 +105,101 @@ func (_m *Database) Close() error {
 	return r0
 }
 
-// Open provides a mock function with given fields: _a0
-func (_m *Database) Open(_a0 *sqlx.DB) error {
-	ret := _m.Called(_a0)
+// CountOrdersByStatus provides a mock function with given fields: ctx
+func (_m *Database) CountOrdersByStatus(ctx context.Context) (map[model.OrderStatus]int64, error) {
+	ret := _m.Called(ctx)
+
+	var r0 map[model.OrderStatus]int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (map[model.OrderStatus]int64, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) map[model.OrderStatus]int64); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[model.OrderStatus]int64)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteExpiredIdempotencyKeys provides a mock function with given fields: ctx
+func (_m *Database) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int64, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteIdempotencyKey provides a mock function with given fields: ctx, userID, key
+func (_m *Database) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+	ret := _m.Called(ctx, userID, key)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*sqlx.DB) error); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, userID, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LockLogin provides a mock function with given fields: ctx, key, until, lockout
+func (_m *Database) LockLogin(ctx context.Context, key string, until time.Time, lockout bool) (bool, error) {
+	ret := _m.Called(ctx, key, until, lockout)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, bool) (bool, error)); ok {
+		return rf(ctx, key, until, lockout)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, bool) bool); ok {
+		r0 = rf(ctx, key, until, lockout)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, bool) error); ok {
+		r1 = rf(ctx, key, until, lockout)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Open provides a mock function with given fields: dsn
+func (_m *Database) Open(dsn string) error {
+	ret := _m.Called(dsn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(dsn)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -85,6 +247,32 @@ func (_m *Database) ReadAccruals(ctx context.Context, userID string) ([]model.Op
 	return r0, r1
 }
 
+// ReadAuditEvents provides a mock function with given fields: ctx, limit
+func (_m *Database) ReadAuditEvents(ctx context.Context, limit int) ([]model.AuditEvent, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []model.AuditEvent
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]model.AuditEvent, error)); ok {
+		return rf(ctx, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []model.AuditEvent); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AuditEvent)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadBalance provides a mock function with given fields: ctx, userID
 func (_m *Database) ReadBalance(ctx context.Context, userID string) (model.BalanceInfo, error) {
 	ret := _m.Called(ctx, userID)
@@ -109,6 +297,80 @@ func (_m *Database) ReadBalance(ctx context.Context, userID string) (model.Balan
 	return r0, r1
 }
 
+// ReadLoginAttempt provides a mock function with given fields: ctx, key
+func (_m *Database) ReadLoginAttempt(ctx context.Context, key string) (model.LoginAttemptInfo, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 model.LoginAttemptInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.LoginAttemptInfo, error)); ok {
+		return rf(ctx, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.LoginAttemptInfo); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(model.LoginAttemptInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadOrder provides a mock function with given fields: ctx, order
+func (_m *Database) ReadOrder(ctx context.Context, order int64) (model.OrderInfo, error) {
+	ret := _m.Called(ctx, order)
+
+	var r0 model.OrderInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (model.OrderInfo, error)); ok {
+		return rf(ctx, order)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) model.OrderInfo); ok {
+		r0 = rf(ctx, order)
+	} else {
+		r0 = ret.Get(0).(model.OrderInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, order)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadOrderHistory provides a mock function with given fields: ctx, order
+func (_m *Database) ReadOrderHistory(ctx context.Context, order int64) ([]model.OrderStatusChange, error) {
+	ret := _m.Called(ctx, order)
+
+	var r0 []model.OrderStatusChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]model.OrderStatusChange, error)); ok {
+		return rf(ctx, order)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []model.OrderStatusChange); ok {
+		r0 = rf(ctx, order)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.OrderStatusChange)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, order)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadOrders provides a mock function with given fields: ctx, userID, orders
 func (_m *Database) ReadOrders(ctx context.Context, userID string, orders ...int64) ([]model.OrderInfo, error) {
 	_va := make([]interface{}, len(orders))
@@ -143,15 +405,15 @@ func (_m *Database) ReadOrders(ctx context.Context, userID string, orders ...int
 }
 
 // ReadOrdersWithStatus provides a mock function with given fields: ctx, status, limit
-func (_m *Database) ReadOrdersWithStatus(ctx context.Context, status []string, limit int) ([]model.OrderInfo, error) {
+func (_m *Database) ReadOrdersWithStatus(ctx context.Context, status []model.OrderStatus, limit int) ([]model.OrderInfo, error) {
 	ret := _m.Called(ctx, status, limit)
 
 	var r0 []model.OrderInfo
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, []string, int) ([]model.OrderInfo, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, []model.OrderStatus, int) ([]model.OrderInfo, error)); ok {
 		return rf(ctx, status, limit)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, []string, int) []model.OrderInfo); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, []model.OrderStatus, int) []model.OrderInfo); ok {
 		r0 = rf(ctx, status, limit)
 	} else {
 		if ret.Get(0) != nil {
@@ -159,7 +421,7 @@ func (_m *Database) ReadOrdersWithStatus(ctx context.Context, status []string, l
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, []string, int) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, []model.OrderStatus, int) error); ok {
 		r1 = rf(ctx, status, limit)
 	} else {
 		r1 = ret.Error(1)
@@ -168,6 +430,30 @@ func (_m *Database) ReadOrdersWithStatus(ctx context.Context, status []string, l
 	return r0, r1
 }
 
+// ReadSession provides a mock function with given fields: ctx, sessionID
+func (_m *Database) ReadSession(ctx context.Context, sessionID string) (model.SessionInfo, error) {
+	ret := _m.Called(ctx, sessionID)
+
+	var r0 model.SessionInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.SessionInfo, error)); ok {
+		return rf(ctx, sessionID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.SessionInfo); ok {
+		r0 = rf(ctx, sessionID)
+	} else {
+		r0 = ret.Get(0).(model.SessionInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, sessionID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadUser provides a mock function with given fields: ctx, userID
 func (_m *Database) ReadUser(ctx context.Context, userID string) (model.UserInfo, error) {
 	ret := _m.Called(ctx, userID)
@@ -218,12 +504,136 @@ func (_m *Database) ReadWithdraws(ctx context.Context, userID string) ([]model.O
 	return r0, r1
 }
 
+// ReconcileBalances provides a mock function with given fields: ctx, fix
+func (_m *Database) ReconcileBalances(ctx context.Context, fix bool) ([]model.BalanceDrift, error) {
+	ret := _m.Called(ctx, fix)
+
+	var r0 []model.BalanceDrift
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, bool) ([]model.BalanceDrift, error)); ok {
+		return rf(ctx, fix)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, bool) []model.BalanceDrift); ok {
+		r0 = rf(ctx, fix)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.BalanceDrift)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, bool) error); ok {
+		r1 = rf(ctx, fix)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RequeueOrder provides a mock function with given fields: ctx, order
+func (_m *Database) RequeueOrder(ctx context.Context, order int64) error {
+	ret := _m.Called(ctx, order)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, order)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetLoginAttempts provides a mock function with given fields: ctx, key
+func (_m *Database) ResetLoginAttempts(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeSession provides a mock function with given fields: ctx, sessionID
+func (_m *Database) RevokeSession(ctx context.Context, sessionID string) error {
+	ret := _m.Called(ctx, sessionID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, sessionID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeUserSessions provides a mock function with given fields: ctx, userID
+func (_m *Database) RevokeUserSessions(ctx context.Context, userID string) error {
+	ret := _m.Called(ctx, userID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RotateSession provides a mock function with given fields: ctx, sessionID, oldHash, newHash, expiresAt
+func (_m *Database) RotateSession(ctx context.Context, sessionID string, oldHash string, newHash string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, sessionID, oldHash, newHash, expiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, time.Time) error); ok {
+		r0 = rf(ctx, sessionID, oldHash, newHash, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SaveIdempotencyResult provides a mock function with given fields: ctx, info
+func (_m *Database) SaveIdempotencyResult(ctx context.Context, info model.IdempotencyInfo) error {
+	ret := _m.Called(ctx, info)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdempotencyInfo) error); ok {
+		r0 = rf(ctx, info)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ScheduleOrder provides a mock function with given fields: ctx, order
+func (_m *Database) ScheduleOrder(ctx context.Context, order model.OrderInfo) error {
+	ret := _m.Called(ctx, order)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderInfo) error); ok {
+		r0 = rf(ctx, order)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateOrderAccrual provides a mock function with given fields: ctx, order, accrual
-func (_m *Database) UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual int) error {
+func (_m *Database) UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error {
 	ret := _m.Called(ctx, order, accrual)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.OrderInfo, int) error); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderInfo, model.Points) error); ok {
 		r0 = rf(ctx, order, accrual)
 	} else {
 		r0 = ret.Error(0)
@@ -232,6 +642,48 @@ func (_m *Database) UpdateOrderAccrual(ctx context.Context, order model.OrderInf
 	return r0
 }
 
+// UpdatePasswordHash provides a mock function with given fields: ctx, userID, oldHash, newHash
+func (_m *Database) UpdatePasswordHash(ctx context.Context, userID string, oldHash string, newHash string) error {
+	ret := _m.Called(ctx, userID, oldHash, newHash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, userID, oldHash, newHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Withdraw provides a mock function with given fields: ctx, userID, order, sum
+func (_m *Database) Withdraw(ctx context.Context, userID string, order int64, sum model.Points) error {
+	ret := _m.Called(ctx, userID, order, sum)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, model.Points) error); ok {
+		r0 = rf(ctx, userID, order, sum)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WriteAuditEvent provides a mock function with given fields: ctx, event
+func (_m *Database) WriteAuditEvent(ctx context.Context, event model.AuditEvent) error {
+	ret := _m.Called(ctx, event)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuditEvent) error); ok {
+		r0 = rf(ctx, event)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // WriteNewOrder provides a mock function with given fields: ctx, userID, order
 func (_m *Database) WriteNewOrder(ctx context.Context, userID string, order int64) error {
 	ret := _m.Called(ctx, userID, order)
@@ -246,13 +698,13 @@ func (_m *Database) WriteNewOrder(ctx context.Context, userID string, order int6
 	return r0
 }
 
-// WriteUser provides a mock function with given fields: ctx, data
-func (_m *Database) WriteUser(ctx context.Context, data model.UserInfo) error {
-	ret := _m.Called(ctx, data)
+// WriteSession provides a mock function with given fields: ctx, session
+func (_m *Database) WriteSession(ctx context.Context, session model.SessionInfo) error {
+	ret := _m.Called(ctx, session)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.UserInfo) error); ok {
-		r0 = rf(ctx, data)
+	if rf, ok := ret.Get(0).(func(context.Context, model.SessionInfo) error); ok {
+		r0 = rf(ctx, session)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -260,13 +712,13 @@ func (_m *Database) WriteUser(ctx context.Context, data model.UserInfo) error {
 	return r0
 }
 
-// WriteWithdraw provides a mock function with given fields: ctx, userID, order, sum
-func (_m *Database) WriteWithdraw(ctx context.Context, userID string, order int64, sum int) error {
-	ret := _m.Called(ctx, userID, order, sum)
+// WriteUser provides a mock function with given fields: ctx, data
+func (_m *Database) WriteUser(ctx context.Context, data model.UserInfo) error {
+	ret := _m.Called(ctx, data)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int) error); ok {
-		r0 = rf(ctx, userID, order, sum)
+	if rf, ok := ret.Get(0).(func(context.Context, model.UserInfo) error); ok {
+		r0 = rf(ctx, data)
 	} else {
 		r0 = ret.Error(0)
 	}
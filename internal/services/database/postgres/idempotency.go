@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+// Захват ключа идемпотентности. Если действующий ключ уже есть,
+// возвращается его запись и ErrWriteConflict
+func (p *PgxStore) ClaimIdempotencyKey(ctx context.Context, info model.IdempotencyInfo) (res model.IdempotencyInfo, err error) {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// просроченный ключ больше не действует, остальные
+	// удаляются периодически в DeleteExpiredIdempotencyKeys
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND idem_key = $2 AND expires_at < $3;`
+	if _, err = tx.ExecContext(ctx, query, info.UserID, info.Key, time.Now()); err != nil {
+		return
+	}
+
+	query = `
+		INSERT INTO idempotency_keys (user_id, idem_key, request_hash, status_code, created_at, expires_at)
+		VALUES (:user_id, :idem_key, :request_hash, 0, :created_at, :expires_at)
+		ON CONFLICT (user_id, idem_key) DO NOTHING;`
+	result, err := tx.NamedExecContext(ctx, query, info)
+	if err != nil {
+		return
+	}
+
+	if n, e := result.RowsAffected(); e != nil {
+		return res, e
+	} else if n == 0 {
+		query = `
+			SELECT user_id, idem_key, request_hash, status_code, response,
+				response_header, created_at, expires_at
+			FROM idempotency_keys
+			WHERE user_id = $1 AND idem_key = $2;`
+		if err = tx.GetContext(ctx, &res, query, info.UserID, info.Key); err != nil {
+			return
+		}
+		return res, database.ErrWriteConflict
+	}
+	return info, tx.Commit()
+}
+
+// Сохранение результата выполнения запроса
+func (p *PgxStore) SaveIdempotencyResult(ctx context.Context, info model.IdempotencyInfo) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = :status_code, response = :response, response_header = :response_header
+		WHERE user_id = :user_id AND idem_key = :idem_key;`
+	result, err := p.db.NamedExecContext(ctx, query, info)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return database.ErrNoContent
+	}
+	return nil
+}
+
+// Удаление ключа, чтобы запрос можно было повторить
+func (p *PgxStore) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND idem_key = $2;`
+	_, err := p.db.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// Удаление просроченных ключей идемпотентности, возвращает количество удалённых
+func (p *PgxStore) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at < $1;`
+	result, err := p.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// Чтение счётчика попыток входа
+func (p *PgxStore) ReadLoginAttempt(ctx context.Context, key string) (attempt model.LoginAttemptInfo, err error) {
+	query := `
+		SELECT attempt_key, failures, last_failure_at, locked_until, lockout
+		FROM login_attempts
+		WHERE attempt_key = $1;`
+	if err = p.db.GetContext(ctx, &attempt, query, key); err != nil {
+		err = errNoContent(err)
+	}
+	return
+}
+
+// Изменение счётчика неудачных попыток на delta. Если последняя попытка
+// была раньше окна или истекла блокировка за превышение порога, счёт
+// начинается заново. Заодно удаляются устаревшие счётчики
+func (p *PgxStore) AddLoginFailures(ctx context.Context, key string, delta int, window time.Duration) (attempt model.LoginAttemptInfo, err error) {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND locked_until < $2;`
+	if _, err = tx.ExecContext(ctx, query, now.Add(-window), now); err != nil {
+		return
+	}
+
+	// строка блокируется до конца транзакции, параллельные попытки
+	// получают разные значения счётчика
+	query = `
+		INSERT INTO login_attempts (attempt_key, failures, last_failure_at, locked_until)
+		VALUES ($1, GREATEST($2, 0), $3, $3)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $4
+					OR (login_attempts.lockout AND login_attempts.locked_until <= $3)
+				THEN GREATEST($2, 0)
+				ELSE GREATEST(login_attempts.failures + $2, 0) END,
+			last_failure_at = CASE WHEN $2 > 0
+				THEN $3 ELSE login_attempts.last_failure_at END,
+			lockout = login_attempts.lockout AND login_attempts.locked_until > $3
+		RETURNING attempt_key, failures, last_failure_at, locked_until, lockout;`
+	if err = tx.GetContext(ctx, &attempt, query, key, delta, now, now.Add(-window)); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// Блокировка попыток входа до указанного времени, если сейчас попытки не заблокированы.
+// lockout - блокировка за превышение порога, после неё счёт начинается заново.
+// Возвращает true, если блокировку установил этот вызов
+func (p *PgxStore) LockLogin(ctx context.Context, key string, until time.Time, lockout bool) (bool, error) {
+	query := `
+		UPDATE login_attempts SET locked_until = $2, lockout = $3
+		WHERE attempt_key = $1 AND locked_until <= $4;`
+	result, err := p.db.ExecContext(ctx, query, key, until, lockout, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Сброс счётчика попыток входа
+func (p *PgxStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	query := `DELETE FROM login_attempts WHERE attempt_key = $1;`
+	_, err := p.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// Запись события в журнал аудита
+func (p *PgxStore) WriteAuditEvent(ctx context.Context, event model.AuditEvent) error {
+	query := `
+		INSERT INTO audit_log (event, subject, remote_addr, details, created_at)
+		VALUES (:event, :subject, :remote_addr, :details, :created_at);`
+	_, err := p.db.NamedExecContext(ctx, query, event)
+	return err
+}
+
+// Чтение последних событий журнала аудита, сначала новые
+func (p *PgxStore) ReadAuditEvents(ctx context.Context, limit int) (res []model.AuditEvent, err error) {
+	res = make([]model.AuditEvent, 0)
+	query := `
+		SELECT event_id, event, subject, remote_addr, details, created_at
+		FROM audit_log
+		ORDER BY event_id DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	err = p.db.SelectContext(ctx, &res, query)
+	return
+}
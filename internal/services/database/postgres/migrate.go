@@ -0,0 +1,257 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+// ключ рекомендательной блокировки, чтобы два экземпляра
+// не применяли миграции одновременно
+const migrationLockKey int64 = 0x676f706865726d
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Отдельная миграция схемы
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Утверждение типа, ошибка компиляции
+var _ database.Migrator = (*PgxStore)(nil)
+
+// Выполнение команды миграции на отдельном соединении
+func (p *PgxStore) Migrate(ctx context.Context, dsn string, command string) ([]database.MigrationStatus, error) {
+	db, err := sqlx.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case database.MigrateUp:
+		err = migrateUp(ctx, db, migrations)
+	case database.MigrateDown:
+		err = migrateDown(ctx, db, migrations)
+	case database.MigrateStatus:
+	default:
+		return nil, fmt.Errorf("unknown migrate command %q", command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return migrationStatus(ctx, db, migrations)
+}
+
+// Чтение встроенных файлов миграций вида 0001_name.up.sql / 0001_name.down.sql
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := path.Base(file)
+
+		num, rest, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", base)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", base, err)
+		}
+
+		body, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			m.name = strings.TrimSuffix(rest, ".up.sql")
+			m.up = string(body)
+		case strings.HasSuffix(rest, ".down.sql"):
+			m.down = string(body)
+		default:
+			return nil, fmt.Errorf("invalid migration direction %q", base)
+		}
+	}
+
+	res := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].version < res[j].version
+	})
+	return res, nil
+}
+
+// Применение всех ещё не применённых миграций
+func migrateUp(ctx context.Context, db *sqlx.DB, migrations []migration) error {
+	return withMigrationLock(ctx, db, func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if err = applyMigration(ctx, conn, m.up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3);`,
+				m.version, m.name, time.Now()); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.version, m.name, err)
+			}
+			logger.Info("migration applied", "version", m.version, "name", m.name)
+		}
+		return nil
+	})
+}
+
+// Откат последней применённой миграции
+func migrateDown(ctx context.Context, db *sqlx.DB, migrations []migration) error {
+	return withMigrationLock(ctx, db, func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", m.version, m.name)
+			}
+			if err = applyMigration(ctx, conn, m.down,
+				`DELETE FROM schema_migrations WHERE version = $1;`, m.version); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.version, m.name, err)
+			}
+			logger.Info("migration reverted", "version", m.version, "name", m.name)
+			return nil
+		}
+		return nil
+	})
+}
+
+// Состояние всех известных миграций
+func migrationStatus(ctx context.Context, db *sqlx.DB, migrations []migration) ([]database.MigrationStatus, error) {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]database.MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		at, ok := applied[m.version]
+		res[i] = database.MigrationStatus{
+			Version:   m.version,
+			Name:      m.name,
+			Applied:   ok,
+			AppliedAt: at,
+		}
+	}
+	return res, nil
+}
+
+// Выполнение функции под рекомендательной блокировкой,
+// блокировка сессионная, поэтому всё выполняется на одном соединении
+func withMigrationLock(ctx context.Context, db *sqlx.DB, fn func(*sqlx.Conn) error) error {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, migrationLockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, migrationLockKey)
+
+	return fn(conn)
+}
+
+// Версии применённых миграций со временем применения
+func appliedVersions(ctx context.Context, conn *sqlx.Conn) (map[int]time.Time, error) {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version		BIGINT PRIMARY KEY,
+			name		TEXT NOT NULL,
+			applied_at	TIMESTAMP WITH TIME ZONE NOT NULL
+		);`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+
+	rows := make([]struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}, 0)
+	query = `SELECT version, applied_at FROM schema_migrations;`
+	if err := conn.SelectContext(ctx, &rows, query); err != nil {
+		return nil, err
+	}
+
+	res := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		res[r.Version] = r.AppliedAt
+	}
+	return res, nil
+}
+
+// Выполнение скрипта миграции и записи о версии в одной транзакции
+func applyMigration(ctx context.Context, conn *sqlx.Conn, script string, query string, args ...any) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version, "versions must be sequential")
+		assert.NotEmpty(t, m.name)
+		assert.NotEmpty(t, m.up, "migration %d has no up script", m.version)
+		assert.NotEmpty(t, m.down, "migration %d has no down script", m.version)
+	}
+}
+
+// Схема для проверки миграций на отдельной базе, удаляется после теста
+func openTestSchema(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := sqlx.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	_, err = admin.ExecContext(ctx, `CREATE SCHEMA `+schema+`;`)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.ExecContext(ctx, `DROP SCHEMA `+schema+` CASCADE;`) })
+
+	u, err := url.Parse(dsn)
+	require.NoError(t, err)
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	db, err := sqlx.Open("pgx", u.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateDuplicateWithdrawals(t *testing.T) {
+	db := openTestSchema(t)
+	ctx := context.Background()
+
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+
+	// схема до уникального индекса списаний
+	require.NoError(t, migrateUp(ctx, db, migrations[:4]))
+
+	// двойное списание по одному заказу уже попало в базу
+	now := time.Now()
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO operations (user_id, order_id, is_accrual, points, uploaded_at) VALUES
+			('user', 1, TRUE, 1000, $1),
+			('user', 2, FALSE, 100, $2),
+			('user', 2, FALSE, 100, $3),
+			('user', 2, FALSE, 100, $3);`,
+		now, now.Add(time.Second), now.Add(2*time.Second))
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO balances (user_id, current, withdrawn) VALUES ('user', 700, 300);`)
+	require.NoError(t, err)
+
+	require.NoError(t, migrateUp(ctx, db, migrations))
+
+	var withdrawals int
+	require.NoError(t, db.GetContext(ctx, &withdrawals,
+		`SELECT COUNT(*) FROM operations WHERE order_id = 2 AND NOT is_accrual;`))
+	assert.Equal(t, 1, withdrawals)
+
+	var balance struct {
+		Current   int64 `db:"current"`
+		Withdrawn int64 `db:"withdrawn"`
+	}
+	require.NoError(t, db.GetContext(ctx, &balance,
+		`SELECT current, withdrawn FROM balances WHERE user_id = 'user';`))
+	assert.Equal(t, int64(900), balance.Current)
+	assert.Equal(t, int64(100), balance.Withdrawn)
+}
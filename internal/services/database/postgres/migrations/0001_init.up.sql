@@ -0,0 +1,25 @@
+-- Начальная схема, совпадает с createTablesIfNonExists,
+-- поэтому безопасно применяется к уже существующим базам
+CREATE TABLE IF NOT EXISTS users (
+	user_id VARCHAR (100) PRIMARY KEY,
+	passwd_hash TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	order_id BIGINT PRIMARY KEY,
+	user_id VARCHAR (100) NOT NULL,
+	status VARCHAR (20) NOT NULL,
+	uploaded_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_idx
+ON orders (user_id);
+CREATE INDEX IF NOT EXISTS status_idx
+ON orders (status);
+
+CREATE TABLE IF NOT EXISTS operations (
+	user_id 	VARCHAR (100) NOT NULL,
+	order_id	BIGINT NOT NULL,
+	is_accrual	BOOL NOT NULL,
+	points		INTEGER NOT NULL,
+	uploaded_at	TIMESTAMP WITH TIME ZONE NOT NULL
+);
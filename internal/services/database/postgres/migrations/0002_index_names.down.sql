@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS operations_user_idx;
+ALTER INDEX IF EXISTS orders_status_idx RENAME TO status_idx;
+ALTER INDEX IF EXISTS orders_user_idx RENAME TO user_idx;
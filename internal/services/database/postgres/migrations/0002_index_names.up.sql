@@ -0,0 +1,6 @@
+-- Имя user_idx было занято индексом orders,
+-- поэтому индекс по operations никогда не создавался
+ALTER INDEX IF EXISTS user_idx RENAME TO orders_user_idx;
+ALTER INDEX IF EXISTS status_idx RENAME TO orders_status_idx;
+CREATE INDEX IF NOT EXISTS operations_user_idx
+ON operations (user_id);
@@ -0,0 +1 @@
+ALTER TABLE operations ALTER COLUMN points TYPE INTEGER;
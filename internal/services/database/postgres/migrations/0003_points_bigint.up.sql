@@ -0,0 +1,2 @@
+-- Баллы хранятся в сотых долях, 32 бит для сумм недостаточно
+ALTER TABLE operations ALTER COLUMN points TYPE BIGINT;
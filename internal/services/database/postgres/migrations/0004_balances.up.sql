@@ -0,0 +1,17 @@
+-- Текущий баланс пользователя, обновляется в одной транзакции с operations
+CREATE TABLE IF NOT EXISTS balances (
+	user_id		VARCHAR (100) PRIMARY KEY,
+	current		BIGINT NOT NULL DEFAULT 0,
+	withdrawn	BIGINT NOT NULL DEFAULT 0,
+	version		BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO balances (user_id, current, withdrawn)
+SELECT
+	u.user_id,
+	COALESCE(SUM(CASE WHEN o.is_accrual THEN o.points ELSE -o.points END), 0),
+	COALESCE(SUM(CASE WHEN o.is_accrual THEN 0 ELSE o.points END), 0)
+FROM (SELECT user_id FROM users UNION SELECT user_id FROM operations) u
+LEFT JOIN operations o ON o.user_id = u.user_id
+GROUP BY u.user_id
+ON CONFLICT (user_id) DO NOTHING;
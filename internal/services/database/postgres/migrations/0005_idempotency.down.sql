@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS idempotency_keys;
+DROP INDEX IF EXISTS operations_withdraw_order_uidx;
@@ -0,0 +1,33 @@
+-- Повторные списания по одному заказу: оставляем самое раннее,
+-- лишнее возвращаем на текущий баланс пользователя
+WITH dup AS (
+	DELETE FROM operations o
+	USING operations k
+	WHERE NOT o.is_accrual AND NOT k.is_accrual AND o.order_id = k.order_id
+		AND (o.uploaded_at, o.ctid) > (k.uploaded_at, k.ctid)
+	RETURNING o.user_id, o.points
+)
+UPDATE balances b SET
+	current = b.current + d.points,
+	withdrawn = b.withdrawn - d.points,
+	version = b.version + 1
+FROM (SELECT user_id, SUM(points) AS points FROM dup GROUP BY user_id) d
+WHERE b.user_id = d.user_id;
+
+-- Номер заказа для списания может использоваться только один раз
+CREATE UNIQUE INDEX IF NOT EXISTS operations_withdraw_order_uidx
+ON operations (order_id) WHERE NOT is_accrual;
+
+-- Результаты запросов с заголовком Idempotency-Key
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id			VARCHAR (100) NOT NULL,
+	idem_key		VARCHAR (255) NOT NULL,
+	request_hash	VARCHAR (64) NOT NULL,
+	status_code		INTEGER NOT NULL DEFAULT 0,
+	response		BYTEA,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	expires_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	PRIMARY KEY (user_id, idem_key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx
+ON idempotency_keys (expires_at);
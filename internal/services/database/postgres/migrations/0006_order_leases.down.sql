@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS lease_owner;
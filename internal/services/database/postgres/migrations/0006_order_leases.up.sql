@@ -0,0 +1,3 @@
+-- Аренда заказа экземпляром сервиса на время опроса системы начислений
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_owner VARCHAR (100);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS operations_accrual_order_uidx;
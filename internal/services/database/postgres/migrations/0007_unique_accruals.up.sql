@@ -0,0 +1,18 @@
+-- Повторные начисления по одному заказу: оставляем самое раннее,
+-- лишнее списываем с текущего баланса пользователя
+WITH dup AS (
+	DELETE FROM operations o
+	USING operations k
+	WHERE o.is_accrual AND k.is_accrual AND o.order_id = k.order_id
+		AND (o.uploaded_at, o.ctid) > (k.uploaded_at, k.ctid)
+	RETURNING o.user_id, o.points
+)
+UPDATE balances b SET
+	current = b.current - d.points,
+	version = b.version + 1
+FROM (SELECT user_id, SUM(points) AS points FROM dup GROUP BY user_id) d
+WHERE b.user_id = d.user_id;
+
+-- По каждому заказу начисление записывается только один раз
+CREATE UNIQUE INDEX IF NOT EXISTS operations_accrual_order_uidx
+ON operations (order_id) WHERE is_accrual;
@@ -0,0 +1,19 @@
+-- REGISTERED системы начислений хранится как NEW,
+-- новые записи с неизвестным статусом не допускаются
+UPDATE orders SET status = 'NEW' WHERE status = 'REGISTERED';
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+CHECK (status IN ('NEW', 'PROCESSING', 'PROCESSED', 'INVALID')) NOT VALID;
+
+-- История изменения статусов заказов
+CREATE TABLE IF NOT EXISTS order_status_history (
+	order_id	BIGINT NOT NULL,
+	from_status	VARCHAR (20),
+	to_status	VARCHAR (20) NOT NULL,
+	changed_at	TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx
+ON order_status_history (order_id, changed_at);
+
+-- для существующих заказов известен только текущий статус
+INSERT INTO order_status_history (order_id, to_status, changed_at)
+SELECT order_id, status, uploaded_at FROM orders;
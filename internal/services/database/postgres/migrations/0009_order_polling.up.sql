@@ -0,0 +1,5 @@
+-- Расписание опроса системы начислений по каждому заказу
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS orders_next_poll_idx
+ON orders (next_poll_at) WHERE status IN ('NEW', 'PROCESSING');
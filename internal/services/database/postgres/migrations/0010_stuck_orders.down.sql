@@ -0,0 +1,6 @@
+UPDATE orders SET status = 'NEW' WHERE status = 'STUCK';
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+CHECK (status IN ('NEW', 'PROCESSING', 'PROCESSED', 'INVALID')) NOT VALID;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS last_error;
@@ -0,0 +1,7 @@
+-- Заказы, которые система начислений так и не обработала,
+-- переводятся в STUCK с последней ошибкой опроса
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_error TEXT;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+CHECK (status IN ('NEW', 'PROCESSING', 'PROCESSED', 'INVALID', 'STUCK')) NOT VALID;
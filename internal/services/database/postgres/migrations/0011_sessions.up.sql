@@ -0,0 +1,14 @@
+-- Сессии пользователей: токен обновления хранится хешем,
+-- завершённые сессии остаются до истечения срока, их токены не принимаются
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id		VARCHAR (64) PRIMARY KEY,
+	user_id			VARCHAR (100) NOT NULL,
+	refresh_hash	VARCHAR (64) NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	expires_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	revoked_at		TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS sessions_user_idx
+ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS sessions_expires_idx
+ON sessions (expires_at);
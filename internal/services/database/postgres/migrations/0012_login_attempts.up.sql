@@ -0,0 +1,21 @@
+-- Счётчики неудачных попыток входа по логину и по адресу клиента
+CREATE TABLE IF NOT EXISTS login_attempts (
+	attempt_key		TEXT PRIMARY KEY, -- логин не ограничен по длине
+	failures		INTEGER NOT NULL DEFAULT 0,
+	last_failure_at	TIMESTAMP WITH TIME ZONE NOT NULL,
+	locked_until	TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_idx
+ON login_attempts (last_failure_at);
+
+-- Журнал аудита событий безопасности
+CREATE TABLE IF NOT EXISTS audit_log (
+	event_id		BIGSERIAL PRIMARY KEY,
+	event			VARCHAR (50) NOT NULL,
+	subject			TEXT NOT NULL,
+	remote_addr		VARCHAR (100) NOT NULL,
+	details			TEXT NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_idx
+ON audit_log (created_at);
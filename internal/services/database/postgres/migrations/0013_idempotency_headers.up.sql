@@ -0,0 +1,2 @@
+-- Заголовки ответа, которые отдаются при повторе запроса
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response_header BYTEA;
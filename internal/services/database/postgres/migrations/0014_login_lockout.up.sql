@@ -0,0 +1,2 @@
+-- После истечения блокировки за превышение порога счёт начинается заново
+ALTER TABLE login_attempts ADD COLUMN IF NOT EXISTS lockout BOOLEAN NOT NULL DEFAULT FALSE;
@@ -22,16 +22,40 @@ type PgxStore struct {
 }
 
 func init() {
-	database.RegDriver(new(PgxStore))
+	driver := func() database.Database {
+		return new(PgxStore)
+	}
+	database.RegDriver("postgres", driver)
+	database.RegDriver("postgresql", driver)
 }
 
 // Утверждение типа, ошибка компиляции
 var _ database.Database = (*PgxStore)(nil)
 
 // Функция открытия БД
-func (p *PgxStore) Open(db *sqlx.DB) error {
+func (p *PgxStore) Open(dsn string) error {
+
+	db, err := sqlx.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+
+	// Настройка пула соединений
+	db.SetMaxOpenConns(3)
+	db.SetMaxIdleConns(3)
+	db.SetConnMaxLifetime(3 * time.Minute)
 
-	if err := createTablesIfNonExists(db); err != nil {
+	// При первом запуске база может быть пустая
+	migrations, err := loadMigrations()
+	if err == nil {
+		err = migrateUp(context.Background(), db, migrations)
+	}
+	if err != nil {
+		db.Close()
 		return err
 	}
 	p.db = db
@@ -43,6 +67,11 @@ func (p *PgxStore) Close() error {
 	return p.db.Close()
 }
 
+// Пул соединений, статистика которого отдаётся в метрики
+func (p *PgxStore) DB() *sql.DB {
+	return p.db.DB
+}
+
 // Пинг к базе
 func (p *PgxStore) Ping(ctx context.Context) error {
 	return p.db.PingContext(ctx)
@@ -63,13 +92,21 @@ func (p *PgxStore) WriteUser(ctx context.Context, data model.UserInfo) error {
 	if _, err = tx.NamedExecContext(ctx, query, data); err != nil {
 		return errWriteConflict(err)
 	}
+
+	// пустой баланс заводится вместе с пользователем
+	query = `
+		INSERT INTO balances (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING;`
+	if _, err = tx.ExecContext(ctx, query, data.UserID); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
 // Чтение данных пользователя
 func (p *PgxStore) ReadUser(ctx context.Context, userID string) (res model.UserInfo, err error) {
 	query := `
-		SELECT * FROM users
+		SELECT user_id, passwd_hash FROM users
 		WHERE user_id = $1 LIMIT 1`
 
 	if err = p.db.GetContext(ctx, &res, query, userID); err != nil {
@@ -78,6 +115,22 @@ func (p *PgxStore) ReadUser(ctx context.Context, userID string) (res model.UserI
 	return
 }
 
+// Замена хеша пароля, если он не изменился с момента чтения
+func (p *PgxStore) UpdatePasswordHash(ctx context.Context, userID string, oldHash, newHash string) error {
+	res, err := p.db.ExecContext(ctx, `
+		UPDATE users SET passwd_hash = $3
+		WHERE user_id = $1 AND passwd_hash = $2`, userID, oldHash, newHash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return database.ErrNoContent
+	}
+	return nil
+}
+
 // Запись закаказа
 func (p *PgxStore) WriteNewOrder(ctx context.Context, userID string, num int64) error {
 	tx, err := p.db.BeginTxx(ctx, nil)
@@ -89,7 +142,7 @@ func (p *PgxStore) WriteNewOrder(ctx context.Context, userID string, num int64)
 	order := model.OrderInfo{
 		UserID:     userID,
 		OrderID:    num,
-		Status:     "NEW",
+		Status:     model.StatusNew,
 		UploadedAt: time.Now(),
 	}
 
@@ -100,16 +153,59 @@ func (p *PgxStore) WriteNewOrder(ctx context.Context, userID string, num int64)
 	if _, err = tx.NamedExecContext(ctx, query, order); err != nil {
 		return errWriteConflict(err)
 	}
+
+	change := model.OrderStatusChange{
+		OrderID:   num,
+		To:        order.Status,
+		ChangedAt: order.UploadedAt,
+	}
+	if err = writeStatusChange(ctx, tx, change); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
+// Запись изменения статуса заказа в историю
+func writeStatusChange(ctx context.Context, tx *sqlx.Tx, data model.OrderStatusChange) error {
+	query := `
+		INSERT INTO order_status_history (order_id, from_status, to_status, changed_at)
+		VALUES(:order_id, NULLIF(:from_status, ''), :to_status, :changed_at);`
+	_, err := tx.NamedExecContext(ctx, query, data)
+	return err
+}
+
+// Чтение заказа по номеру независимо от пользователя
+func (p *PgxStore) ReadOrder(ctx context.Context, num int64) (res model.OrderInfo, err error) {
+	query := `
+		SELECT user_id, order_id, status, uploaded_at, next_poll_at, attempts,
+			COALESCE(last_error, '') AS last_error
+		FROM orders WHERE order_id = $1;`
+
+	if err = p.db.GetContext(ctx, &res, query, num); err != nil {
+		err = errNoContent(err)
+	}
+	return
+}
+
+// История изменения статусов заказа
+func (p *PgxStore) ReadOrderHistory(ctx context.Context, num int64) (res []model.OrderStatusChange, err error) {
+	res = make([]model.OrderStatusChange, 0)
+
+	query := `
+		SELECT order_id, COALESCE(from_status, '') AS from_status, to_status, changed_at
+		FROM order_status_history
+		WHERE order_id = $1 ORDER BY changed_at;`
+	err = p.db.SelectContext(ctx, &res, query, num)
+	return
+}
+
 // читаем заказы указанного пользователя по списку номеров, если номера не указаны - читаем всё
 func (p *PgxStore) ReadOrders(ctx context.Context, userID string, nums ...int64) (res []model.OrderInfo, err error) {
 	res = make([]model.OrderInfo, 0)
 
 	if len(nums) == 0 {
 		query := `
-			SELECT * FROM orders 
+			SELECT user_id, order_id, status, uploaded_at FROM orders 
 			WHERE user_id = $1;`
 		err = p.db.SelectContext(ctx, &res, query, userID)
 	} else {
@@ -118,7 +214,7 @@ func (p *PgxStore) ReadOrders(ctx context.Context, userID string, nums ...int64)
 			args  []any
 		)
 		query, args, err = sqlx.In(`
-			SELECT * FROM orders
+			SELECT user_id, order_id, status, uploaded_at FROM orders
 			WHERE user_id = ? AND order_id IN (?) LIMIT ?;`, userID, nums, len(nums))
 		if err != nil {
 			return nil, err
@@ -128,58 +224,212 @@ func (p *PgxStore) ReadOrders(ctx context.Context, userID string, nums ...int64)
 	return
 }
 
+// Захват заказов указанных статусов, время опроса которых наступило.
+// Заказы, заблокированные другой транзакцией, пропускаются, поэтому несколько
+// экземпляров сервиса получают разные заказы. Аренда, не освобождённая вовремя,
+// захватывается снова. Первыми возвращаются заказы, дольше всех ждущие опроса
+func (p *PgxStore) ClaimOrders(ctx context.Context, owner string, status []model.OrderStatus, limit int, lease time.Duration) (res []model.OrderInfo, err error) {
+	res = make([]model.OrderInfo, 0)
+	if len(status) == 0 {
+		return
+	}
+
+	query := `
+		WITH claimed AS (
+			UPDATE orders SET lease_owner = ?, 
+				lease_expires_at = now() + ? * interval '1 millisecond'
+			WHERE order_id IN (
+				SELECT order_id FROM orders
+				WHERE status IN (?) AND next_poll_at <= now()
+					AND (lease_expires_at IS NULL OR lease_expires_at < now())
+				ORDER BY next_poll_at, order_id %s
+				FOR UPDATE SKIP LOCKED)
+			RETURNING user_id, order_id, status, uploaded_at, lease_owner, next_poll_at, attempts)
+		SELECT * FROM claimed ORDER BY next_poll_at, order_id;`
+
+	limitClause := ""
+	if limit > 0 {
+		limitClause = fmt.Sprintf("LIMIT %d", limit)
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(query, limitClause), owner, lease.Milliseconds(), status)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.db.SelectContext(ctx, &res, p.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Освобождение аренды заказа без изменения его состояния
+// и назначение времени следующего опроса
+func (p *PgxStore) ScheduleOrder(ctx context.Context, order model.OrderInfo) error {
+	if order.NextPollAt.IsZero() {
+		order.NextPollAt = time.Now()
+	}
+	query := `
+		UPDATE orders SET lease_owner = NULL, lease_expires_at = NULL,
+			next_poll_at = :next_poll_at, attempts = :attempts,
+			last_error = NULLIF(:last_error, '')
+		WHERE order_id = :order_id AND lease_owner = :lease_owner;`
+	_, err := p.db.NamedExecContext(ctx, query, order)
+	return err
+}
+
 // читаем заказы всех пользователей указанных статусов
-func (p *PgxStore) ReadOrdersWithStatus(ctx context.Context, status []string, limit int) (res []model.OrderInfo, err error) {
+func (p *PgxStore) ReadOrdersWithStatus(ctx context.Context, status []model.OrderStatus, limit int) (res []model.OrderInfo, err error) {
 	res = make([]model.OrderInfo, 0)
-	query := `SELECT * FROM orders %s`
+	if len(status) == 0 {
+		return
+	}
 
+	query := `
+		SELECT user_id, order_id, status, uploaded_at, next_poll_at, attempts,
+			COALESCE(last_error, '') AS last_error
+		FROM orders WHERE status IN (?)
+		ORDER BY uploaded_at, order_id`
 	if limit > 0 {
 		query += fmt.Sprintf(" LIMIT %d", limit)
 	}
 
-	if len(status) == 0 {
-		err = p.db.SelectContext(ctx, &res, fmt.Sprintf(query, ""))
-	} else {
-		var args []any
-		query, args, err = sqlx.In(fmt.Sprintf(query, `WHERE status IN (?)`),
-			status)
-		if err != nil {
-			return nil, err
-		}
-		err = p.db.SelectContext(ctx, &res, p.db.Rebind(query), args...)
+	query, args, err := sqlx.In(query, status)
+	if err != nil {
+		return nil, err
 	}
+	err = p.db.SelectContext(ctx, &res, p.db.Rebind(query), args...)
 	return
 }
 
-// Запись информации о списании
-func (p *PgxStore) WriteWithdraw(ctx context.Context, userID string, num int64, sum int) error {
-	return p.writeOperations(ctx, model.OperationsInfo{
-		UserID:     userID,
-		OrderID:    num,
-		Points:     sum,
-		IsAccrual:  false,
-		UploadedAt: time.Now(),
-	})
+// Количество заказов всех пользователей по статусам
+func (p *PgxStore) CountOrdersByStatus(ctx context.Context) (map[model.OrderStatus]int64, error) {
+	var rows []struct {
+		Status model.OrderStatus `db:"status"`
+		Count  int64             `db:"count"`
+	}
+	err := p.db.SelectContext(ctx, &rows,
+		`SELECT status, count(*) AS count FROM orders GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[model.OrderStatus]int64, len(rows))
+	for _, r := range rows {
+		res[r.Status] = r.Count
+	}
+	return res, nil
 }
 
-// Запись сведений о лояльности
-func (p *PgxStore) writeOperations(ctx context.Context, data model.OperationsInfo) error {
+// Возврат зависшего заказа в очередь опроса
+func (p *PgxStore) RequeueOrder(ctx context.Context, num int64) error {
 	tx, err := p.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// добавление записи о заказе
+	var status model.OrderStatus
 	query := `
-		INSERT INTO operations (user_id, order_id, is_accrual, points, uploaded_at) 
-		VALUES(:user_id, :order_id, :is_accrual, :points, :uploaded_at);`
-	if _, err = tx.NamedExecContext(ctx, query, data); err != nil {
+		SELECT status FROM orders
+		WHERE order_id = $1 FOR UPDATE;`
+	if err = tx.GetContext(ctx, &status, query, num); err != nil {
+		return errNoContent(err)
+	}
+	if status != model.StatusStuck {
+		return fmt.Errorf("%w: %s -> %s", database.ErrInvalidTransition, status, model.StatusNew)
+	}
+
+	query = `
+		UPDATE orders SET status = $2, attempts = 0, next_poll_at = now(),
+			last_error = NULL, lease_owner = NULL, lease_expires_at = NULL
+		WHERE order_id = $1;`
+	if _, err = tx.ExecContext(ctx, query, num, model.StatusNew); err != nil {
+		return err
+	}
+
+	change := model.OrderStatusChange{
+		OrderID:   num,
+		From:      status,
+		To:        model.StatusNew,
+		ChangedAt: time.Now(),
+	}
+	if err = writeStatusChange(ctx, tx, change); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
+// Списание баллов. Строка баланса блокируется до конца транзакции,
+// поэтому параллельные списания одного пользователя выполняются по очереди
+// и не могут вместе увести баланс в минус
+func (p *PgxStore) Withdraw(ctx context.Context, userID string, num int64, sum model.Points) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current model.Points
+	query := `
+		SELECT current FROM balances
+		WHERE user_id = $1 FOR UPDATE;`
+	if err = tx.GetContext(ctx, &current, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.ErrInsufficientFunds
+		}
+		return err
+	}
+	if current < sum {
+		return database.ErrInsufficientFunds
+	}
+
+	op := model.OperationsInfo{
+		UserID:     userID,
+		OrderID:    num,
+		Points:     sum,
+		IsAccrual:  false,
+		UploadedAt: time.Now(),
+	}
+	if err = writeOperations(ctx, tx, op); err != nil {
+		return errWriteConflict(err)
+	}
+	return tx.Commit()
+}
+
+// Запись сведений о лояльности и изменение баланса в той же транзакции.
+// Начисление и списание по одному заказу записываются только один раз,
+// повторная запись возвращает ErrWriteConflict без изменения баланса
+func writeOperations(ctx context.Context, tx *sqlx.Tx, data model.OperationsInfo) error {
+	query := `
+		INSERT INTO operations (user_id, order_id, is_accrual, points, uploaded_at) 
+		VALUES(:user_id, :order_id, :is_accrual, :points, :uploaded_at)
+		ON CONFLICT DO NOTHING;`
+	res, err := tx.NamedExecContext(ctx, query, data)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return database.ErrWriteConflict
+	}
+
+	current, withdrawn := data.Points, model.Points(0)
+	if !data.IsAccrual {
+		current, withdrawn = -data.Points, data.Points
+	}
+
+	query = `
+		INSERT INTO balances AS b (user_id, current, withdrawn, version)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (user_id) DO UPDATE SET
+			current = b.current + EXCLUDED.current,
+			withdrawn = b.withdrawn + EXCLUDED.withdrawn,
+			version = b.version + 1;`
+	_, err = tx.ExecContext(ctx, query, data.UserID, current, withdrawn)
+	return err
+}
+
 func (p *PgxStore) ReadWithdraws(ctx context.Context, userID string) ([]model.OperationsInfo, error) {
 	return p.readOperations(ctx, userID, false)
 }
@@ -203,12 +453,8 @@ func (p *PgxStore) readOperations(ctx context.Context, userID string, isAccrual
 // читаем баланс пользователя
 func (p *PgxStore) ReadBalance(ctx context.Context, userID string) (res model.BalanceInfo, err error) {
 	query := `
-		SELECT
-			user_id,
-			SUM(CASE WHEN is_accrual THEN points ELSE -points END) AS current,
-			SUM(CASE WHEN is_accrual THEN 0 ELSE points END) AS withdrawn 
-		FROM operations WHERE user_id = $1
-		GROUP BY user_id;`
+		SELECT user_id, current, withdrawn, version
+		FROM balances WHERE user_id = $1;`
 
 	if err = p.db.GetContext(ctx, &res, query, userID); err != nil {
 		err = errNoContent(err)
@@ -216,71 +462,85 @@ func (p *PgxStore) ReadBalance(ctx context.Context, userID string) (res model.Ba
 	return
 }
 
-// Обновление сведений заказа, добавление записей о начислении скидок
-func (p *PgxStore) UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual int) error {
+// Обновление сведений заказа, добавление записей о начислении скидок.
+// Заказ, захваченный для опроса, обновляется только владельцем аренды,
+// аренда при этом освобождается. Повтор окончательного статуса ничего не меняет,
+// недопустимый переход статуса отклоняется, остальные записываются в историю
+func (p *PgxStore) UpdateOrderAccrual(ctx context.Context, order model.OrderInfo, accrual model.Points) error {
 	tx, err := p.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil
+		return err
 	}
 	defer tx.Rollback()
 
+	var current struct {
+		UserID     string            `db:"user_id"`
+		Status     model.OrderStatus `db:"status"`
+		LeaseOwner sql.NullString    `db:"lease_owner"`
+	}
 	query := `
-		UPDATE orders SET user_id=:user_id, status=:status, uploaded_at=:uploaded_at  
+		SELECT user_id, status, lease_owner FROM orders
+		WHERE order_id = $1 FOR UPDATE;`
+	if err = tx.GetContext(ctx, &current, query, order.OrderID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil // заказа нет, обновлять нечего
+		}
+		return err
+	}
+
+	changed := current.Status != order.Status
+	switch {
+	case order.LeaseOwner != "" && current.LeaseOwner.String != order.LeaseOwner:
+		return database.ErrLeaseLost
+	case !changed && current.Status.IsFinal():
+		return nil // повторный ответ об уже обработанном заказе
+	case changed && !current.Status.CanTransitionTo(order.Status):
+		return fmt.Errorf("%w: %s -> %s", database.ErrInvalidTransition, current.Status, order.Status)
+	}
+
+	// владелец и время загрузки заказа не меняются, начисление
+	// получает владелец из базы, а не из переданной структуры
+	order.UserID = current.UserID
+	if order.NextPollAt.IsZero() {
+		order.NextPollAt = time.Now()
+	}
+	query = `
+		UPDATE orders SET status = :status,
+			lease_owner = NULL, lease_expires_at = NULL,
+			next_poll_at = :next_poll_at, attempts = :attempts,
+			last_error = NULLIF(:last_error, '')
 		WHERE order_id = :order_id;`
-	_, err = tx.NamedExecContext(ctx, query, order)
-	if err != nil {
+	if _, err = tx.NamedExecContext(ctx, query, order); err != nil {
 		return err
 	}
 
-	if accrual != 0 {
-		query = `
-			INSERT INTO operations (user_id, order_id, is_accrual, points, uploaded_at) 
-			VALUES(:user_id, :order_id, :is_accrual, :points, :uploaded_at);`
-		_, err = tx.NamedExecContext(ctx, query, model.OperationsInfo{
-			UserID:    order.UserID,
+	if changed {
+		change := model.OrderStatusChange{
 			OrderID:   order.OrderID,
-			IsAccrual: true,
-			Points:    accrual,
-		})
-		if err != nil {
+			From:      current.Status,
+			To:        order.Status,
+			ChangedAt: time.Now(),
+		}
+		if err = writeStatusChange(ctx, tx, change); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
-}
+	if accrual != 0 {
+		op := model.OperationsInfo{
+			UserID:     order.UserID,
+			OrderID:    order.OrderID,
+			IsAccrual:  true,
+			Points:     accrual,
+			UploadedAt: time.Now(),
+		}
+		// начисление по заказу уже записано
+		if err = writeOperations(ctx, tx, op); err != nil && !errors.Is(err, database.ErrWriteConflict) {
+			return err
+		}
+	}
 
-// При первом запуске база может быть пустая
-func createTablesIfNonExists(db *sqlx.DB) error {
-	query := `
-		CREATE TABLE IF NOT EXISTS users (
-			user_id VARCHAR (100) PRIMARY KEY,
-			passwd_hash TEXT NOT NULL
-		);
-
-		CREATE TABLE IF NOT EXISTS orders (
-			order_id BIGINT PRIMARY KEY,
-			user_id VARCHAR (100) NOT NULL,
-			status VARCHAR (20) NOT NULL,
-			uploaded_at TIMESTAMP WITH TIME ZONE NOT NULL
-		);
-		CREATE INDEX IF NOT EXISTS user_idx 
-		ON orders (user_id);
-		CREATE INDEX IF NOT EXISTS status_idx 
-		ON orders (status);
-
-		CREATE TABLE IF NOT EXISTS operations (
-			user_id 	VARCHAR (100) NOT NULL,
-			order_id	BIGINT NOT NULL,
-			is_accrual	BOOL NOT NULL,
-			points		INTEGER NOT NULL,
-			uploaded_at	TIMESTAMP WITH TIME ZONE NOT NULL
-		);
-		CREATE INDEX IF NOT EXISTS user_idx 
-		ON operations (user_id);
-		`
-	_, err := db.Exec(query)
-	return err
+	return tx.Commit()
 }
 
 func errWriteConflict(err error) error {
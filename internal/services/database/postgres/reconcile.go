@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// пересчёт баланса пользователей по истории операций
+const expectedBalancesQuery = `
+	SELECT
+		user_id,
+		SUM(CASE WHEN is_accrual THEN points ELSE -points END)::BIGINT AS current,
+		SUM(CASE WHEN is_accrual THEN 0 ELSE points END)::BIGINT AS withdrawn
+	FROM operations %s
+	GROUP BY user_id`
+
+// Сверка таблицы balances с историей operations.
+// Возвращает найденные расхождения, при fix исправляет их
+func (p *PgxStore) ReconcileBalances(ctx context.Context, fix bool) ([]model.BalanceDrift, error) {
+	drifts, err := p.readBalanceDrifts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range drifts {
+		logger.Warn("balance drift",
+			"user_id", d.UserID,
+			"current", d.Current,
+			"expected_current", d.ExpectedCurrent,
+			"withdrawn", d.Withdrawn,
+			"expected_withdrawn", d.ExpectedWithdrawn)
+
+		if fix {
+			if err = p.fixBalance(ctx, d.UserID); err != nil {
+				return drifts, err
+			}
+		}
+	}
+	return drifts, nil
+}
+
+// чтение расхождений на согласованном снимке данных
+func (p *PgxStore) readBalanceDrifts(ctx context.Context) ([]model.BalanceDrift, error) {
+	tx, err := p.db.BeginTxx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res := make([]model.BalanceDrift, 0)
+	query := `
+		SELECT
+			COALESCE(b.user_id, o.user_id) AS user_id,
+			COALESCE(b.current, 0) AS current,
+			COALESCE(b.withdrawn, 0) AS withdrawn,
+			COALESCE(o.current, 0) AS expected_current,
+			COALESCE(o.withdrawn, 0) AS expected_withdrawn
+		FROM balances b
+		FULL JOIN (` + fmt.Sprintf(expectedBalancesQuery, "") + `) o
+		ON o.user_id = b.user_id
+		WHERE COALESCE(b.current, 0) <> COALESCE(o.current, 0)
+			OR COALESCE(b.withdrawn, 0) <> COALESCE(o.withdrawn, 0);`
+	if err = tx.SelectContext(ctx, &res, query); err != nil {
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// исправление баланса пользователя под блокировкой строки баланса
+func (p *PgxStore) fixBalance(ctx context.Context, userID string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO balances (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING;`
+	if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	query = `
+		SELECT user_id FROM balances
+		WHERE user_id = $1 FOR UPDATE;`
+	if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE balances AS b SET
+			current = COALESCE(o.current, 0),
+			withdrawn = COALESCE(o.withdrawn, 0),
+			version = b.version + 1
+		FROM (SELECT $1::VARCHAR AS user_id) u
+		LEFT JOIN (` + fmt.Sprintf(expectedBalancesQuery, "WHERE user_id = $1") + `) o
+		ON o.user_id = u.user_id
+		WHERE b.user_id = u.user_id;`
+	if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+// Запись новой сессии, заодно удаляются истёкшие
+func (p *PgxStore) WriteSession(ctx context.Context, session model.SessionInfo) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM sessions WHERE expires_at < $1;`
+	if _, err = tx.ExecContext(ctx, query, time.Now()); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO sessions (session_id, user_id, refresh_hash, created_at, expires_at)
+		VALUES (:session_id, :user_id, :refresh_hash, :created_at, :expires_at);`
+	if _, err = tx.NamedExecContext(ctx, query, session); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Чтение сессии, в том числе завершённой
+func (p *PgxStore) ReadSession(ctx context.Context, sessionID string) (session model.SessionInfo, err error) {
+	query := `
+		SELECT session_id, user_id, refresh_hash, created_at, expires_at,
+			revoked_at IS NOT NULL AS revoked
+		FROM sessions
+		WHERE session_id = $1;`
+	if err = p.db.GetContext(ctx, &session, query, sessionID); err != nil {
+		err = errNoContent(err)
+	}
+	return
+}
+
+// Замена токена обновления действующей сессии. Если токен уже заменён
+// или сессия завершена, возвращается ErrNoContent
+func (p *PgxStore) RotateSession(ctx context.Context, sessionID string, oldHash, newHash string, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions SET refresh_hash = $3, expires_at = $4
+		WHERE session_id = $1 AND refresh_hash = $2
+			AND revoked_at IS NULL AND expires_at > $5;`
+	result, err := p.db.ExecContext(ctx, query, sessionID, oldHash, newHash, expiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return database.ErrNoContent
+	}
+	return nil
+}
+
+// Завершение сессии
+func (p *PgxStore) RevokeSession(ctx context.Context, sessionID string) error {
+	query := `
+		UPDATE sessions SET revoked_at = $2
+		WHERE session_id = $1 AND revoked_at IS NULL;`
+	_, err := p.db.ExecContext(ctx, query, sessionID, time.Now())
+	return err
+}
+
+// Завершение всех сессий пользователя
+func (p *PgxStore) RevokeUserSessions(ctx context.Context, userID string) error {
+	query := `
+		UPDATE sessions SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL;`
+	_, err := p.db.ExecContext(ctx, query, userID, time.Now())
+	return err
+}
@@ -0,0 +1,217 @@
+// Ключи подписи JWT-токенов пользователей
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Поддерживаемые алгоритмы подписи
+const (
+	HS256 = jwa.HS256
+	RS256 = jwa.RS256
+	EdDSA = jwa.EdDSA
+)
+
+// Наименьшая длина ключа HS256 в байтах
+const minSecretLength = 32
+
+var (
+	ErrNoKeys           = errors.New("no jwt signing keys")
+	ErrUnknownAlgorithm = errors.New("unknown jwt signing algorithm")
+)
+
+// Набор ключей: новые токены подписываются одним ключом,
+// а проверяются всеми ключами набора по заголовку kid
+type KeySet struct {
+	sign    jwk.Key
+	signAlg jwa.SignatureAlgorithm
+	verify  jwk.Set
+}
+
+// Набор из ключей JWKS. Подписывает ключ signKID, по умолчанию последний в наборе
+func New(set jwk.Set, signKID string) (*KeySet, error) {
+	if set.Len() == 0 {
+		return nil, ErrNoKeys
+	}
+
+	var sign jwk.Key
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+		if signKID == "" || key.KeyID() == signKID {
+			sign = key
+		}
+	}
+	if sign == nil {
+		return nil, fmt.Errorf("jwt signing key %q not found", signKID)
+	}
+	if sign.KeyType() != jwa.OctetSeq && !isPrivate(sign) {
+		return nil, fmt.Errorf("jwt signing key %q is not a private key", sign.KeyID())
+	}
+
+	verify, err := jwk.PublicSetOf(set)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{
+		sign:    sign,
+		signAlg: jwa.SignatureAlgorithm(sign.Algorithm().String()),
+		verify:  verify,
+	}, nil
+}
+
+// Загрузка набора из файла JWKS
+func Load(path string, signKID string) (*KeySet, error) {
+	set, err := jwk.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwt keys: %w", err)
+	}
+	return New(set, signKID)
+}
+
+// Набор из одного ключа HS256, идентификатор получается из секрета
+func FromSecret(secret string) (*KeySet, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("jwt secret must be at least %d bytes", minSecretLength)
+	}
+	key, err := newKey(HS256, []byte(secret), "")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if err = key.Set(jwk.KeyIDKey, fmt.Sprintf("%x", sum[:4])); err != nil {
+		return nil, err
+	}
+	return newKeySet(key)
+}
+
+// Набор из случайного ключа HS256. Токены действуют до перезапуска
+// и только на этом экземпляре сервиса
+func Ephemeral() (*KeySet, error) {
+	key, err := Generate(HS256, "ephemeral")
+	if err != nil {
+		return nil, err
+	}
+	return newKeySet(key)
+}
+
+// Новый закрытый ключ для файла JWKS, по умолчанию идентификатор - время создания
+func Generate(alg jwa.SignatureAlgorithm, kid string) (jwk.Key, error) {
+	var (
+		raw interface{}
+		err error
+	)
+	switch alg {
+	case HS256:
+		secret := make([]byte, minSecretLength)
+		_, err = rand.Read(secret)
+		raw = secret
+	case RS256:
+		raw, err = rsa.GenerateKey(rand.Reader, 2048)
+	case EdDSA:
+		_, raw, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		kid = time.Now().UTC().Format("20060102-150405")
+	}
+	return newKey(alg, raw, kid)
+}
+
+// Идентификатор ключа подписи
+func (k *KeySet) SigningKeyID() string {
+	return k.sign.KeyID()
+}
+
+// Подпись токена текущим ключом, идентификатор ключа пишется в заголовок kid
+func (k *KeySet) Sign(token jwt.Token) ([]byte, error) {
+	return jwt.Sign(token, jwt.WithKey(k.signAlg, k.sign))
+}
+
+// Разбор и проверка токена. Ключ выбирается по kid и своему алгоритму,
+// токены без kid или с другим алгоритмом не принимаются
+func (k *KeySet) Parse(token string) (jwt.Token, error) {
+	return jwt.ParseString(token,
+		jwt.WithKeySet(k.verify, jws.WithRequireKid(true)),
+		jwt.WithValidate(true))
+}
+
+// набор из одного ключа
+func newKeySet(key jwk.Key) (*KeySet, error) {
+	set := jwk.NewSet()
+	if err := set.AddKey(key); err != nil {
+		return nil, err
+	}
+	return New(set, "")
+}
+
+// ключ JWK с алгоритмом и идентификатором
+func newKey(alg jwa.SignatureAlgorithm, raw interface{}, kid string) (jwk.Key, error) {
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err = key.Set(jwk.AlgorithmKey, alg); err != nil {
+		return nil, err
+	}
+	if kid != "" {
+		if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// проверка, что у ключа есть идентификатор и алгоритм подходит к типу ключа
+func checkKey(key jwk.Key) error {
+	if key.KeyID() == "" {
+		return fmt.Errorf("jwt key without kid")
+	}
+
+	var want jwa.KeyType
+	switch jwa.SignatureAlgorithm(key.Algorithm().String()) {
+	case HS256:
+		want = jwa.OctetSeq
+	case RS256:
+		want = jwa.RSA
+	case EdDSA:
+		want = jwa.OKP
+	default:
+		return fmt.Errorf("%w: key %q alg %q", ErrUnknownAlgorithm, key.KeyID(), key.Algorithm())
+	}
+	if key.KeyType() != want {
+		return fmt.Errorf("jwt key %q: alg %s does not match key type %s",
+			key.KeyID(), key.Algorithm(), key.KeyType())
+	}
+
+	if sym, ok := key.(jwk.SymmetricKey); ok && len(sym.Octets()) < minSecretLength {
+		return fmt.Errorf("jwt key %q must be at least %d bytes", key.KeyID(), minSecretLength)
+	}
+	return nil
+}
+
+// закрытый ли асимметричный ключ
+func isPrivate(key jwk.Key) bool {
+	switch key.(type) {
+	case jwk.RSAPrivateKey, jwk.OKPPrivateKey:
+		return true
+	}
+	return false
+}
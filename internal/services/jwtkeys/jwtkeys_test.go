@@ -0,0 +1,204 @@
+package jwtkeys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newToken(t *testing.T) jwt.Token {
+	token, err := jwt.NewBuilder().
+		Expiration(time.Now().Add(time.Hour)).
+		Claim("user_id", "user").
+		Build()
+	require.NoError(t, err)
+	return token
+}
+
+func newSet(t *testing.T, keys ...jwk.Key) jwk.Set {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		require.NoError(t, set.AddKey(key))
+	}
+	return set
+}
+
+func generate(t *testing.T, alg jwa.SignatureAlgorithm, kid string) jwk.Key {
+	key, err := Generate(alg, kid)
+	require.NoError(t, err)
+	return key
+}
+
+func TestSignParse(t *testing.T) {
+	for _, alg := range []jwa.SignatureAlgorithm{HS256, RS256, EdDSA} {
+		t.Run(alg.String(), func(t *testing.T) {
+			keys, err := New(newSet(t, generate(t, alg, "key")), "")
+			require.NoError(t, err)
+			assert.Equal(t, "key", keys.SigningKeyID())
+
+			signed, err := keys.Sign(newToken(t))
+			require.NoError(t, err)
+
+			token, err := keys.Parse(string(signed))
+			require.NoError(t, err)
+			assert.Equal(t, "user", token.PrivateClaims()["user_id"])
+
+			// испорченная подпись
+			_, err = keys.Parse(string(signed[:len(signed)-2]) + "AA")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey := generate(t, HS256, "old")
+	newKey := generate(t, EdDSA, "new")
+
+	oldKeys, err := New(newSet(t, oldKey), "")
+	require.NoError(t, err)
+	oldToken, err := oldKeys.Sign(newToken(t))
+	require.NoError(t, err)
+
+	// новый ключ добавлен, подпись пока старым
+	keys, err := New(newSet(t, oldKey, newKey), "old")
+	require.NoError(t, err)
+	assert.Equal(t, "old", keys.SigningKeyID())
+
+	// подпись новым ключом, старые токены действуют
+	keys, err = New(newSet(t, oldKey, newKey), "")
+	require.NoError(t, err)
+	assert.Equal(t, "new", keys.SigningKeyID())
+	_, err = keys.Parse(string(oldToken))
+	assert.NoError(t, err)
+
+	newToken, err := keys.Sign(newToken(t))
+	require.NoError(t, err)
+	// экземпляр, ещё не знающий новый ключ, такой токен не примет
+	_, err = oldKeys.Parse(string(newToken))
+	assert.Error(t, err)
+
+	// старый ключ удалён
+	keys, err = New(newSet(t, newKey), "")
+	require.NoError(t, err)
+	_, err = keys.Parse(string(oldToken))
+	assert.Error(t, err)
+	_, err = keys.Parse(string(newToken))
+	assert.NoError(t, err)
+}
+
+func TestParseRejects(t *testing.T) {
+	key := generate(t, HS256, "key")
+	keys, err := New(newSet(t, key), "")
+	require.NoError(t, err)
+
+	// без kid
+	signed, err := jwt.Sign(newToken(t), jwt.WithKey(jwa.HS256, []byte(strings.Repeat("k", 32))))
+	require.NoError(t, err)
+	_, err = keys.Parse(string(signed))
+	assert.Error(t, err)
+
+	// тот же kid, но другой алгоритм
+	var secret []byte
+	require.NoError(t, key.Raw(&secret))
+	other, err := jwk.FromRaw(secret)
+	require.NoError(t, err)
+	require.NoError(t, other.Set(jwk.KeyIDKey, "key"))
+	signed, err = jwt.Sign(newToken(t), jwt.WithKey(jwa.HS512, other))
+	require.NoError(t, err)
+	_, err = keys.Parse(string(signed))
+	assert.Error(t, err)
+
+	// истёкший
+	expired, err := jwt.NewBuilder().Expiration(time.Now().Add(-time.Minute)).Build()
+	require.NoError(t, err)
+	signed, err = keys.Sign(expired)
+	require.NoError(t, err)
+	_, err = keys.Parse(string(signed))
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired())
+}
+
+func TestNewErrors(t *testing.T) {
+	rsaKey := generate(t, RS256, "rsa")
+	public, err := rsaKey.PublicKey()
+	require.NoError(t, err)
+
+	noKid := generate(t, HS256, "")
+	require.NoError(t, noKid.Remove(jwk.KeyIDKey))
+
+	wrongAlg := generate(t, RS256, "wrong")
+	require.NoError(t, wrongAlg.Set(jwk.AlgorithmKey, jwa.HS256))
+
+	short, err := newKey(HS256, []byte("short"), "short")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		set     jwk.Set
+		signKID string
+	}{
+		{"empty", jwk.NewSet(), ""},
+		{"unknown kid", newSet(t, rsaKey), "other"},
+		{"public signing key", newSet(t, public), ""},
+		{"no kid", newSet(t, noKid), ""},
+		{"alg does not match key", newSet(t, wrongAlg), ""},
+		{"short secret", newSet(t, short), ""},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			_, err := New(tcase.set, tcase.signKID)
+			assert.Error(t, err)
+		})
+	}
+
+	// открытый ключ годится для проверки, если подписывает другой
+	_, err = New(newSet(t, public, generate(t, EdDSA, "ed")), "ed")
+	assert.NoError(t, err)
+
+	_, err = Generate("none", "")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `{"keys":[` +
+		`{"kty":"oct","kid":"a","alg":"HS256","k":"` + strings.Repeat("A", 43) + `"},` +
+		`{"kty":"oct","kid":"b","alg":"HS256","k":"` + strings.Repeat("B", 43) + `"}]}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	keys, err := Load(path, "")
+	require.NoError(t, err)
+	assert.Equal(t, "b", keys.SigningKeyID())
+
+	keys, err = Load(path, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", keys.SigningKeyID())
+
+	_, err = Load(filepath.Join(t.TempDir(), "none.json"), "")
+	assert.Error(t, err)
+}
+
+func TestFromSecret(t *testing.T) {
+	_, err := FromSecret("short")
+	assert.Error(t, err)
+
+	secret := strings.Repeat("s", 32)
+	a, err := FromSecret(secret)
+	require.NoError(t, err)
+	b, err := FromSecret(secret)
+	require.NoError(t, err)
+	// одинаковый секрет - одинаковый kid на всех экземплярах
+	assert.Equal(t, a.SigningKeyID(), b.SigningKeyID())
+
+	signed, err := a.Sign(newToken(t))
+	require.NoError(t, err)
+	_, err = b.Parse(string(signed))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,226 @@
+// Защита входа от перебора паролей: счётчики неудачных попыток по логину
+// и по адресу клиента, нарастающая задержка и временная блокировка
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugene982/yp-gophermart/internal/logger"
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+)
+
+// Событие журнала аудита при блокировке входа
+const EventLoginLockout = "login_lockout"
+
+// Префиксы ключей счётчиков, ключ же - субъект события аудита
+const (
+	LoginKeyPrefix = "login:"
+	IPKeyPrefix    = "ip:"
+)
+
+// Значения по умолчанию
+const (
+	DefaultWindow        = time.Hour
+	DefaultLockout       = 15 * time.Minute
+	DefaultBaseDelay     = time.Second
+	DefaultMaxDelay      = 30 * time.Second
+	DefaultLoginFree     = 3
+	DefaultLoginFailures = 10
+	DefaultIPFree        = 20
+	DefaultIPFailures    = 100
+)
+
+// адрес в журнале аудита, если он неизвестен
+const unknownAddr = "unknown"
+
+// Хранилище счётчиков попыток и журнала аудита
+type Store interface {
+	ReadLoginAttempt(ctx context.Context, key string) (model.LoginAttemptInfo, error)
+	AddLoginFailures(ctx context.Context, key string, delta int, window time.Duration) (model.LoginAttemptInfo, error)
+	LockLogin(ctx context.Context, key string, until time.Time, lockout bool) (bool, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+	WriteAuditEvent(ctx context.Context, event model.AuditEvent) error
+}
+
+// Правило ограничения попыток для одного вида счётчика
+type Policy struct {
+	FreeAttempts int // неудачных попыток без задержки
+	MaxFailures  int // после стольких неудач вход блокируется, 0 - без блокировки
+}
+
+// Настройки защиты, нулевые длительности заменяются значениями по умолчанию
+type Options struct {
+	Login     Policy
+	IP        Policy
+	Window    time.Duration // счётчик сбрасывается, если неудач не было дольше окна
+	Lockout   time.Duration // длительность блокировки
+	BaseDelay time.Duration // задержка после первой неудачи сверх бесплатных, далее удваивается
+	MaxDelay  time.Duration
+}
+
+// Настройки по умолчанию
+func DefaultOptions() Options {
+	return Options{
+		Login:     Policy{FreeAttempts: DefaultLoginFree, MaxFailures: DefaultLoginFailures},
+		IP:        Policy{FreeAttempts: DefaultIPFree, MaxFailures: DefaultIPFailures},
+		Window:    DefaultWindow,
+		Lockout:   DefaultLockout,
+		BaseDelay: DefaultBaseDelay,
+		MaxDelay:  DefaultMaxDelay,
+	}
+}
+
+// Учёт попыток входа. Попытка засчитывается неудачной ещё до проверки
+// пароля и прощается при успехе, поэтому параллельные запросы
+// не обходят ограничение
+type Guard struct {
+	store Store
+	opts  Options
+}
+
+// Создание защиты входа
+func New(store Store, opts Options) *Guard {
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.Lockout <= 0 {
+		opts.Lockout = DefaultLockout
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = DefaultBaseDelay
+	}
+	if opts.MaxDelay < opts.BaseDelay {
+		opts.MaxDelay = opts.BaseDelay
+	}
+	return &Guard{store: store, opts: opts}
+}
+
+// счётчик попыток и правило для него
+type counter struct {
+	key    string
+	policy Policy
+}
+
+func (g *Guard) counters(login, ip string) []counter {
+	res := []counter{{key: LoginKeyPrefix + login, policy: g.opts.Login}}
+	if ip != "" {
+		res = append(res, counter{key: IPKeyPrefix + ip, policy: g.opts.IP})
+	}
+	return res
+}
+
+// Начало попытки входа. Если вход сейчас запрещён, возвращает
+// время, через которое можно повторить
+func (g *Guard) BeginLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	now := time.Now()
+	counters := g.counters(login, ip)
+
+	var retryAfter time.Duration
+	for _, c := range counters {
+		attempt, err := g.store.ReadLoginAttempt(ctx, c.key)
+		if errors.Is(err, database.ErrNoContent) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if d := attempt.LockedUntil.Sub(now); d > retryAfter {
+			retryAfter = d
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter, nil
+	}
+
+	for _, c := range counters {
+		attempt, err := g.store.AddLoginFailures(ctx, c.key, 1, g.opts.Window)
+		if err != nil {
+			return 0, err
+		}
+		if c.policy.MaxFailures <= 0 || attempt.Failures <= c.policy.MaxFailures {
+			continue
+		}
+
+		locked, err := g.store.LockLogin(ctx, c.key, now.Add(g.opts.Lockout), true)
+		if err != nil {
+			return 0, err
+		}
+		if locked {
+			g.audit(ctx, c.key, ip, attempt.Failures, now.Add(g.opts.Lockout))
+		}
+		retryAfter = g.opts.Lockout
+	}
+	return retryAfter, nil
+}
+
+// Неудачная попытка входа, после бесплатных попыток вход
+// откладывается на нарастающее время
+func (g *Guard) LoginFailed(ctx context.Context, login, ip string) error {
+	now := time.Now()
+	for _, c := range g.counters(login, ip) {
+		attempt, err := g.store.ReadLoginAttempt(ctx, c.key)
+		if errors.Is(err, database.ErrNoContent) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if delay := g.delay(attempt.Failures, c.policy); delay > 0 {
+			if _, err = g.store.LockLogin(ctx, c.key, now.Add(delay), false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Успешный вход: счётчик логина сбрасывается, попытка с адреса прощается
+func (g *Guard) LoginSucceeded(ctx context.Context, login, ip string) error {
+	counters := g.counters(login, ip)
+	if err := g.store.ResetLoginAttempts(ctx, counters[0].key); err != nil {
+		return err
+	}
+	for _, c := range counters[1:] {
+		if _, err := g.store.AddLoginFailures(ctx, c.key, -1, g.opts.Window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// задержка после указанного числа неудач
+func (g *Guard) delay(failures int, policy Policy) time.Duration {
+	n := failures - policy.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	delay := g.opts.BaseDelay
+	for i := 1; i < n && delay < g.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.opts.MaxDelay {
+		delay = g.opts.MaxDelay
+	}
+	return delay
+}
+
+// запись блокировки в журнал аудита, ошибка записи не мешает блокировке
+func (g *Guard) audit(ctx context.Context, key, ip string, failures int, until time.Time) {
+	if ip == "" {
+		ip = unknownAddr
+	}
+	event := model.AuditEvent{
+		Event:      EventLoginLockout,
+		Subject:    key,
+		RemoteAddr: ip,
+		Details:    fmt.Sprintf("failures=%d locked_until=%s", failures, until.UTC().Format(time.RFC3339)),
+		CreatedAt:  time.Now(),
+	}
+	logger.FromContext(ctx).Warn("audit", "event", event.Event, "subject", event.Subject,
+		"remote_addr", event.RemoteAddr, "details", event.Details)
+	if err := g.store.WriteAuditEvent(ctx, event); err != nil {
+		logger.FromContext(ctx).Error(fmt.Errorf("write audit event: %w", err))
+	}
+}
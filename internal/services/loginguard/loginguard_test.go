@@ -0,0 +1,210 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/services/database/memory"
+)
+
+func testOptions() Options {
+	return Options{
+		Login:     Policy{FreeAttempts: 2, MaxFailures: 4},
+		IP:        Policy{FreeAttempts: 10, MaxFailures: 20},
+		Lockout:   time.Minute,
+		BaseDelay: 50 * time.Millisecond,
+		MaxDelay:  100 * time.Millisecond,
+	}
+}
+
+func TestDelay(t *testing.T) {
+	g := New(memory.New(), testOptions())
+	policy := Policy{FreeAttempts: 2}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 50 * time.Millisecond},
+		{4, 100 * time.Millisecond},
+		{10, 100 * time.Millisecond},
+	}
+	for _, tcase := range tests {
+		assert.Equal(t, tcase.want, g.delay(tcase.failures, policy), tcase.failures)
+	}
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	ctx := context.Background()
+	g := New(memory.New(), testOptions())
+
+	// бесплатные попытки без задержки
+	for i := 0; i < 2; i++ {
+		retry, err := g.BeginLogin(ctx, "user", "192.0.2.1")
+		require.NoError(t, err)
+		require.Zero(t, retry)
+		require.NoError(t, g.LoginFailed(ctx, "user", "192.0.2.1"))
+	}
+
+	retry, err := g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	require.Zero(t, retry)
+	require.NoError(t, g.LoginFailed(ctx, "user", "192.0.2.1"))
+
+	// после третьей неудачи вход откладывается
+	retry, err = g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Greater(t, retry, time.Duration(0))
+
+	// задержка действует и с другого адреса
+	retry, err = g.BeginLogin(ctx, "user", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Greater(t, retry, time.Duration(0))
+
+	// другой логин не затронут
+	retry, err = g.BeginLogin(ctx, "another", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, retry)
+
+	time.Sleep(60 * time.Millisecond)
+	retry, err = g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, retry)
+
+	// успешный вход сбрасывает счётчик логина
+	require.NoError(t, g.LoginSucceeded(ctx, "user", "192.0.2.1"))
+	retry, err = g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, retry)
+}
+
+func TestLockout(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	g := New(store, testOptions())
+
+	// параллельные попытки засчитываются до проверки пароля,
+	// поэтому к проверке допускается не больше MaxFailures
+	const n = 20
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retry, err := g.BeginLogin(ctx, "user", "192.0.2.1")
+			require.NoError(t, err)
+			if retry == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 4, allowed)
+
+	retry, err := g.BeginLogin(ctx, "user", "192.0.2.3")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, retry, float64(time.Second))
+
+	// блокировка попадает в журнал аудита один раз
+	events, err := store.ReadAuditEvents(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventLoginLockout, events[0].Event)
+	assert.Equal(t, "login:user", events[0].Subject)
+	assert.Equal(t, "192.0.2.1", events[0].RemoteAddr)
+}
+
+func TestLockoutByIP(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	opts := testOptions()
+	opts.IP = Policy{FreeAttempts: 100, MaxFailures: 3}
+	g := New(store, opts)
+
+	// перебор логинов с одного адреса
+	for _, login := range []string{"a", "b", "c"} {
+		retry, err := g.BeginLogin(ctx, login, "192.0.2.1")
+		require.NoError(t, err)
+		require.Zero(t, retry)
+		require.NoError(t, g.LoginFailed(ctx, login, "192.0.2.1"))
+	}
+
+	retry, err := g.BeginLogin(ctx, "d", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Greater(t, retry, time.Duration(0))
+
+	// с другого адреса вход возможен
+	retry, err = g.BeginLogin(ctx, "d", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, retry)
+
+	events, err := store.ReadAuditEvents(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "ip:192.0.2.1", events[0].Subject)
+}
+
+func TestSuccessForgivesIP(t *testing.T) {
+	ctx := context.Background()
+	opts := testOptions()
+	opts.IP = Policy{FreeAttempts: 100, MaxFailures: 2}
+	g := New(memory.New(), opts)
+
+	// успешные входы пользователей за общим адресом не копятся
+	for i := 0; i < 5; i++ {
+		retry, err := g.BeginLogin(ctx, "user", "192.0.2.1")
+		require.NoError(t, err)
+		require.Zero(t, retry, i)
+		require.NoError(t, g.LoginSucceeded(ctx, "user", "192.0.2.1"))
+	}
+}
+
+func TestLoginAfterLockout(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	opts := testOptions()
+	opts.Login = Policy{FreeAttempts: 100, MaxFailures: 2}
+	opts.Lockout = 50 * time.Millisecond
+	g := New(store, opts)
+
+	for i := 0; i < 2; i++ {
+		retry, err := g.BeginLogin(ctx, "user", "192.0.2.1")
+		require.NoError(t, err)
+		require.Zero(t, retry)
+		require.NoError(t, g.LoginFailed(ctx, "user", "192.0.2.1"))
+	}
+	retry, err := g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	require.Greater(t, retry, time.Duration(0))
+
+	// по истечении блокировки одна неудачная попытка не блокирует вход
+	// снова и не пишется в аудит: счёт начинается заново
+	time.Sleep(60 * time.Millisecond)
+	retry, err = g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	require.Zero(t, retry)
+	require.NoError(t, g.LoginFailed(ctx, "user", "192.0.2.1"))
+
+	// верный пароль принимается
+	retry, err = g.BeginLogin(ctx, "user", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, retry)
+	require.NoError(t, g.LoginSucceeded(ctx, "user", "192.0.2.1"))
+
+	events, err := store.ReadAuditEvents(ctx, 0)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
@@ -0,0 +1,189 @@
+// Хеширование паролей пользователей
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// Алгоритмы хеширования новых паролей
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Параметры по умолчанию: argon2id по второму рекомендованному
+// варианту RFC 9106, bcrypt - с запасом от bcrypt.DefaultCost
+const (
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024 // КиБ
+	DefaultArgon2Threads = 4
+	DefaultBcryptCost    = 12
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+// Настройки хеширования, нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	Algorithm string // алгоритм новых хешей, по умолчанию argon2id
+
+	Argon2Time    uint32
+	Argon2Memory  uint32 // КиБ
+	Argon2Threads uint8
+	BcryptCost    int
+
+	// соль устаревшего формата SHA-256, пусто - такие хеши не принимаются
+	LegacySalt string
+}
+
+// Хеширование и проверка паролей. Хеш хранится вместе с параметрами
+// в формате PHC ($argon2id$v=19$m=...,t=...,p=...$соль$хеш) или bcrypt ($2a$...),
+// поэтому параметры можно менять без потери старых хешей
+type Hasher struct {
+	opts Options
+}
+
+// Создание хешера
+func NewHasher(opts Options) (*Hasher, error) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = Argon2id
+	}
+	if opts.Algorithm != Argon2id && opts.Algorithm != Bcrypt {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, opts.Algorithm)
+	}
+	if opts.Argon2Time == 0 {
+		opts.Argon2Time = DefaultArgon2Time
+	}
+	if opts.Argon2Memory == 0 {
+		opts.Argon2Memory = DefaultArgon2Memory
+	}
+	if opts.Argon2Threads == 0 {
+		opts.Argon2Threads = DefaultArgon2Threads
+	}
+	if opts.BcryptCost == 0 {
+		opts.BcryptCost = DefaultBcryptCost
+	}
+	if opts.BcryptCost < bcrypt.MinCost || opts.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("invalid bcrypt cost %d", opts.BcryptCost)
+	}
+	return &Hasher{opts: opts}, nil
+}
+
+// Хеш пароля с текущими параметрами
+func (h *Hasher) Hash(r model.LoginReqest) (string, error) {
+	if h.opts.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), h.opts.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := argon2Params{
+		memory:  h.opts.Argon2Memory,
+		time:    h.opts.Argon2Time,
+		threads: h.opts.Argon2Threads,
+	}
+	key := argon2.IDKey([]byte(r.Password), salt, p.time, p.memory, p.threads, argon2KeyLength)
+	return p.encode(salt, key), nil
+}
+
+// Проверка пароля по сохранённому хешу. rehash - пароль верный, но хеш
+// записан устаревшим алгоритмом или с другими параметрами и его нужно заменить
+func (h *Hasher) Verify(r model.LoginReqest, encoded string) (ok bool, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(r.Password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		return true, h.opts.Algorithm != Argon2id || p.time != h.opts.Argon2Time ||
+			p.memory != h.opts.Argon2Memory || p.threads != h.opts.Argon2Threads, nil
+
+	case strings.HasPrefix(encoded, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(r.Password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, fmt.Errorf("%w: %s", ErrMalformedHash, err)
+		}
+		cost, _ := bcrypt.Cost([]byte(encoded))
+		return true, h.opts.Algorithm != Bcrypt || cost != h.opts.BcryptCost, nil
+
+	case h.opts.LegacySalt != "":
+		// устаревший формат всегда заменяется
+		legacy := legacyHash(r, h.opts.LegacySalt)
+		return subtle.ConstantTimeCompare([]byte(legacy), []byte(encoded)) == 1, true, nil
+	}
+	return false, false, ErrMalformedHash
+}
+
+// Хеш устаревшего формата. Sum дописывает хеш пустой строки к своему
+// аргументу, поэтому пароль в нём хранится в hex без хеширования.
+// Повторяется как есть только для проверки и замены старых записей
+func legacyHash(r model.LoginReqest, salt string) string {
+	h := sha256.New()
+	return fmt.Sprintf("%x", h.Sum([]byte(r.Password+salt+r.Login)))
+}
+
+// параметры argon2id
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// запись хеша в формате PHC
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+// разбор хеша в формате PHC
+func decodeArgon2(encoded string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("%w: version %q", ErrMalformedHash, parts[2])
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: params %q", ErrMalformedHash, parts[3])
+	}
+	if p.time == 0 || p.threads == 0 {
+		return p, nil, nil, fmt.Errorf("%w: params %q", ErrMalformedHash, parts[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: salt", ErrMalformedHash)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("%w: key", ErrMalformedHash)
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,160 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+)
+
+// быстрые параметры для тестов
+var testOptions = Options{
+	Argon2Time:    1,
+	Argon2Memory:  64,
+	Argon2Threads: 1,
+	BcryptCost:    bcrypt.MinCost,
+	LegacySalt:    "salt",
+}
+
+func newTestHasher(t *testing.T, algorithm string) *Hasher {
+	opts := testOptions
+	opts.Algorithm = algorithm
+	h, err := NewHasher(opts)
+	require.NoError(t, err)
+	return h
+}
+
+func TestNewHasher(t *testing.T) {
+	h, err := NewHasher(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, Argon2id, h.opts.Algorithm)
+	assert.Equal(t, uint32(DefaultArgon2Time), h.opts.Argon2Time)
+	assert.Equal(t, DefaultBcryptCost, h.opts.BcryptCost)
+
+	_, err = NewHasher(Options{Algorithm: "md5"})
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+
+	_, err = NewHasher(Options{Algorithm: Bcrypt, BcryptCost: 100})
+	assert.Error(t, err)
+}
+
+func TestHashVerify(t *testing.T) {
+	user := model.LoginReqest{Login: "user", Password: "password"}
+	wrong := model.LoginReqest{Login: "user", Password: "wrong"}
+
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{Argon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{Bcrypt, "$2a$04$"},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.algorithm, func(t *testing.T) {
+			h := newTestHasher(t, tcase.algorithm)
+
+			hash, err := h.Hash(user)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(hash, tcase.prefix), hash)
+
+			// соль случайная
+			other, err := h.Hash(user)
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, other)
+
+			ok, rehash, err := h.Verify(user, hash)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.False(t, rehash)
+
+			ok, rehash, err = h.Verify(wrong, hash)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.False(t, rehash)
+		})
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	user := model.LoginReqest{Login: "user", Password: "password"}
+
+	argonHash, err := newTestHasher(t, Argon2id).Hash(user)
+	require.NoError(t, err)
+	bcryptHash, err := newTestHasher(t, Bcrypt).Hash(user)
+	require.NoError(t, err)
+
+	stronger := testOptions
+	stronger.Argon2Time = 2
+	stronger.BcryptCost = bcrypt.MinCost + 1
+	strongerHasher, err := NewHasher(stronger)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		hasher *Hasher
+		hash   string
+		rehash bool
+	}{
+		{"same argon2id", newTestHasher(t, Argon2id), argonHash, false},
+		{"argon2id params changed", strongerHasher, argonHash, true},
+		{"argon2id to bcrypt", newTestHasher(t, Bcrypt), argonHash, true},
+		{"same bcrypt", newTestHasher(t, Bcrypt), bcryptHash, false},
+		{"bcrypt cost changed", strongerHasher, bcryptHash, true},
+		{"bcrypt to argon2id", newTestHasher(t, Argon2id), bcryptHash, true},
+		{"legacy", newTestHasher(t, Argon2id), legacyHash(user, "salt"), true},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			ok, rehash, err := tcase.hasher.Verify(user, tcase.hash)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, tcase.rehash, rehash)
+		})
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	user := model.LoginReqest{Login: "user", Password: "password"}
+	legacy := legacyHash(user, "salt")
+
+	h := newTestHasher(t, Argon2id)
+	ok, _, err := h.Verify(model.LoginReqest{Login: "user", Password: "wrong"}, legacy)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// без соли устаревшие хеши не принимаются
+	opts := testOptions
+	opts.LegacySalt = ""
+	noLegacy, err := NewHasher(opts)
+	require.NoError(t, err)
+	_, _, err = noLegacy.Verify(user, legacy)
+	assert.ErrorIs(t, err, ErrMalformedHash)
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	user := model.LoginReqest{Login: "user", Password: "password"}
+	h := newTestHasher(t, Argon2id)
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"parts", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{"version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"},
+		{"params", "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5"},
+		{"salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5"},
+		{"key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$"},
+		{"bcrypt", "$2a$04$short"},
+	}
+	for _, tcase := range tests {
+		t.Run(tcase.name, func(t *testing.T) {
+			ok, _, err := h.Verify(user, tcase.hash)
+			assert.ErrorIs(t, err, ErrMalformedHash)
+			assert.False(t, ok)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// Сессии пользователей: короткие токены доступа и сменяемые токены обновления
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/eugene982/yp-gophermart/internal/model"
+	"github.com/eugene982/yp-gophermart/internal/services/database"
+	"github.com/eugene982/yp-gophermart/internal/services/jwtkeys"
+)
+
+// Время жизни токенов по умолчанию
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Имена утверждений токена доступа
+const (
+	claimUserID    = "user_id"
+	claimSessionID = "sid"
+)
+
+var (
+	// токен не подходит: подпись, срок, сессия завершена или не найдена
+	ErrInvalidToken = errors.New("invalid token")
+	// предъявлен уже заменённый токен обновления, сессия завершена
+	ErrTokenReused = fmt.Errorf("%w: refresh token reused", ErrInvalidToken)
+)
+
+// Хранилище сессий
+type Store interface {
+	WriteSession(ctx context.Context, session model.SessionInfo) error
+	ReadSession(ctx context.Context, sessionID string) (model.SessionInfo, error)
+	RotateSession(ctx context.Context, sessionID string, oldHash, newHash string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+}
+
+// Настройки сессий, нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Выдача и проверка токенов. Токен доступа - JWT с идентификатором сессии,
+// токен обновления - "<сессия>.<секрет>", в хранилище лежит только хеш секрета
+// и при каждом обновлении он заменяется
+type Manager struct {
+	keys  *jwtkeys.KeySet
+	store Store
+	opts  Options
+}
+
+// Создание менеджера сессий
+func New(keys *jwtkeys.KeySet, store Store, opts Options) *Manager {
+	if opts.AccessTTL <= 0 {
+		opts.AccessTTL = DefaultAccessTTL
+	}
+	if opts.RefreshTTL <= 0 {
+		opts.RefreshTTL = DefaultRefreshTTL
+	}
+	return &Manager{keys: keys, store: store, opts: opts}
+}
+
+// Новая сессия пользователя
+func (m *Manager) StartSession(ctx context.Context, userID string) (model.Tokens, error) {
+	sessionID, err := randomString(16)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	now := time.Now()
+	session := model.SessionInfo{
+		SessionID:   sessionID,
+		UserID:      userID,
+		RefreshHash: hashSecret(secret),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(m.opts.RefreshTTL),
+	}
+	if err = m.store.WriteSession(ctx, session); err != nil {
+		return model.Tokens{}, err
+	}
+	return m.issue(session, secret, now)
+}
+
+// Обмен токена обновления на новую пару токенов. Повторное предъявление
+// заменённого токена означает его утечку, поэтому сессия завершается
+func (m *Manager) RefreshSession(ctx context.Context, refreshToken string) (model.Tokens, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return model.Tokens{}, ErrInvalidToken
+	}
+
+	session, err := m.store.ReadSession(ctx, sessionID)
+	if errors.Is(err, database.ErrNoContent) {
+		return model.Tokens{}, ErrInvalidToken
+	} else if err != nil {
+		return model.Tokens{}, err
+	}
+
+	now := time.Now()
+	if session.Revoked || !session.ExpiresAt.After(now) {
+		return model.Tokens{}, ErrInvalidToken
+	}
+	oldHash := hashSecret(secret)
+	if oldHash != session.RefreshHash {
+		return model.Tokens{}, m.revokeReused(ctx, sessionID)
+	}
+
+	newSecret, err := randomString(32)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	session.RefreshHash = hashSecret(newSecret)
+	session.ExpiresAt = now.Add(m.opts.RefreshTTL)
+
+	// параллельное обновление тем же токеном успеет только одно
+	err = m.store.RotateSession(ctx, sessionID, oldHash, session.RefreshHash, session.ExpiresAt)
+	if errors.Is(err, database.ErrNoContent) {
+		return model.Tokens{}, m.revokeReused(ctx, sessionID)
+	} else if err != nil {
+		return model.Tokens{}, err
+	}
+	return m.issue(session, newSecret, now)
+}
+
+// Завершение сессии, её токены больше не принимаются
+func (m *Manager) EndSession(ctx context.Context, sessionID string) error {
+	return m.store.RevokeSession(ctx, sessionID)
+}
+
+// Завершение всех сессий пользователя
+func (m *Manager) EndUserSessions(ctx context.Context, userID string) error {
+	return m.store.RevokeUserSessions(ctx, userID)
+}
+
+// Проверка токена доступа: подпись, срок и то, что сессия не завершена
+func (m *Manager) VerifyAccessToken(ctx context.Context, accessToken string) (model.TokenClaims, error) {
+	token, err := m.keys.Parse(accessToken)
+	if err != nil {
+		return model.TokenClaims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	claims := model.TokenClaims{}
+	claims.UserID, _ = token.PrivateClaims()[claimUserID].(string)
+	claims.SessionID, _ = token.PrivateClaims()[claimSessionID].(string)
+	if claims.UserID == "" || claims.SessionID == "" {
+		return model.TokenClaims{}, fmt.Errorf("%w: missing claims", ErrInvalidToken)
+	}
+
+	session, err := m.store.ReadSession(ctx, claims.SessionID)
+	if errors.Is(err, database.ErrNoContent) {
+		return model.TokenClaims{}, fmt.Errorf("%w: session not found", ErrInvalidToken)
+	} else if err != nil {
+		return model.TokenClaims{}, err
+	}
+	if session.Revoked || session.UserID != claims.UserID {
+		return model.TokenClaims{}, fmt.Errorf("%w: session revoked", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// выдача пары токенов сессии
+func (m *Manager) issue(session model.SessionInfo, secret string, now time.Time) (model.Tokens, error) {
+	accessExpiresAt := now.Add(m.opts.AccessTTL)
+	if accessExpiresAt.After(session.ExpiresAt) {
+		accessExpiresAt = session.ExpiresAt
+	}
+
+	token, err := jwt.NewBuilder().
+		IssuedAt(now).
+		Expiration(accessExpiresAt).
+		Claim(claimUserID, session.UserID).
+		Claim(claimSessionID, session.SessionID).
+		Build()
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	signed, err := m.keys.Sign(token)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return model.Tokens{
+		AccessToken:      string(signed),
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     session.SessionID + "." + secret,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// завершение сессии, токен обновления которой предъявлен повторно
+func (m *Manager) revokeReused(ctx context.Context, sessionID string) error {
+	if err := m.store.RevokeSession(ctx, sessionID); err != nil {
+		return errors.Join(ErrTokenReused, err)
+	}
+	return ErrTokenReused
+}
+
+// случайная строка из n байт
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// хеш секрета токена обновления, секрет случайный, поэтому соль не нужна
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,158 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eugene982/yp-gophermart/internal/services/database/memory"
+	"github.com/eugene982/yp-gophermart/internal/services/jwtkeys"
+)
+
+func newTestManager(t *testing.T, opts Options) *Manager {
+	keys, err := jwtkeys.Ephemeral()
+	require.NoError(t, err)
+	return New(keys, memory.New(), opts)
+}
+
+func TestStartVerify(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, Options{})
+
+	tokens, err := m.StartSession(ctx, "user")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(DefaultAccessTTL), tokens.AccessExpiresAt, time.Second)
+	assert.WithinDuration(t, time.Now().Add(DefaultRefreshTTL), tokens.RefreshExpiresAt, time.Second)
+
+	claims, err := m.VerifyAccessToken(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.UserID)
+	assert.NotEmpty(t, claims.SessionID)
+
+	// токен, подписанный другим ключом
+	other := newTestManager(t, Options{})
+	_, err = other.VerifyAccessToken(ctx, tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// токен доступа не годится для обновления и наоборот
+	_, err = m.RefreshSession(ctx, tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.VerifyAccessToken(ctx, tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, Options{})
+
+	first, err := m.StartSession(ctx, "user")
+	require.NoError(t, err)
+
+	second, err := m.RefreshSession(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	claims, err := m.VerifyAccessToken(ctx, second.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.UserID)
+
+	// повтор заменённого токена завершает сессию целиком
+	_, err = m.RefreshSession(ctx, first.RefreshToken)
+	assert.ErrorIs(t, err, ErrTokenReused)
+
+	_, err = m.RefreshSession(ctx, second.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.VerifyAccessToken(ctx, second.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRefreshConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, Options{})
+
+	tokens, err := m.StartSession(ctx, "user")
+	require.NoError(t, err)
+
+	const n = 10
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		succ int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.RefreshSession(ctx, tokens.RefreshToken); err == nil {
+				mu.Lock()
+				succ++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, succ)
+}
+
+func TestRefreshInvalid(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, Options{})
+
+	for _, token := range []string{"", "garbage", ".secret", "session.", "unknown.secret"} {
+		_, err := m.RefreshSession(ctx, token)
+		assert.ErrorIs(t, err, ErrInvalidToken, token)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, Options{})
+
+	first, err := m.StartSession(ctx, "user")
+	require.NoError(t, err)
+	second, err := m.StartSession(ctx, "user")
+	require.NoError(t, err)
+	another, err := m.StartSession(ctx, "another")
+	require.NoError(t, err)
+
+	claims, err := m.VerifyAccessToken(ctx, first.AccessToken)
+	require.NoError(t, err)
+	require.NoError(t, m.EndSession(ctx, claims.SessionID))
+
+	// выход завершает только свою сессию
+	_, err = m.VerifyAccessToken(ctx, first.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.RefreshSession(ctx, first.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.VerifyAccessToken(ctx, second.AccessToken)
+	assert.NoError(t, err)
+
+	// смена пароля завершает все сессии пользователя
+	require.NoError(t, m.EndUserSessions(ctx, "user"))
+	_, err = m.VerifyAccessToken(ctx, second.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.RefreshSession(ctx, second.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.VerifyAccessToken(ctx, another.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestExpired(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, Options{AccessTTL: time.Hour, RefreshTTL: time.Second})
+
+	tokens, err := m.StartSession(ctx, "user")
+	require.NoError(t, err)
+	// токен доступа не переживает сессию
+	assert.Equal(t, tokens.RefreshExpiresAt, tokens.AccessExpiresAt)
+
+	time.Sleep(time.Second + 100*time.Millisecond)
+	_, err = m.VerifyAccessToken(ctx, tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = m.RefreshSession(ctx, tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -3,16 +3,8 @@ package utils
 import (
 	"fmt"
 	"strconv"
-
-	"github.com/eugene982/yp-gophermart/internal/model"
 )
 
-type HasherFunc func(model.LoginReqest) string
-
-func (f HasherFunc) Hash(r model.LoginReqest) string {
-	return f(r)
-}
-
 // Проверка корректности номера заказа
 func OrderNumberToInt(order string) (int64, error) {
 